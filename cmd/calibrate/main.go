@@ -0,0 +1,61 @@
+package main
+
+// Calibrate - fits per-model, per-label confidence calibrators from a labeled JSONL dataset
+//
+// Each dataset line is one predicted intent or entity:
+//   {"model":"openai/gpt-4o-mini","kind":"intent","label":"greet","confidence":0.92,"correct":true}
+
+import (
+	"eino_llm_poc/src/llm/nlu"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+func main() {
+	dataPath := flag.String("data", "", "labeled JSONL dataset")
+	outPath := flag.String("out", "calibration.json", "calibration file to write (NLU_CALIBRATION_FILE)")
+	method := flag.String("method", string(nlu.CalibrationPlatt), "calibration method: platt or isotonic")
+	minSamples := flag.Int("min-samples", nlu.DefaultCalibrationMinSamples, "minimum examples per label calibrator")
+	flag.Parse()
+
+	if *dataPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: calibrate -data labeled.jsonl [-out calibration.json] [-method platt|isotonic]")
+		os.Exit(2)
+	}
+
+	file, err := os.Open(*dataPath)
+	if err != nil {
+		fmt.Printf("Error opening dataset: %v\n", err)
+		os.Exit(1)
+	}
+	examples, err := nlu.ReadLabeledExamples(file)
+	file.Close()
+	if err != nil {
+		fmt.Printf("Error reading dataset: %v\n", err)
+		os.Exit(1)
+	}
+
+	calibrations, err := nlu.FitCalibrations(examples, nlu.CalibrationMethod(*method), *minSamples)
+	if err != nil {
+		fmt.Printf("Error fitting calibrators: %v\n", err)
+		os.Exit(1)
+	}
+	if err := nlu.SaveCalibrations(*outPath, calibrations); err != nil {
+		fmt.Printf("Error saving calibrators: %v\n", err)
+		os.Exit(1)
+	}
+
+	models := make([]string, 0, len(calibrations.Models))
+	for modelName := range calibrations.Models {
+		models = append(models, modelName)
+	}
+	sort.Strings(models)
+
+	fmt.Printf("Fitted %s calibrators from %d examples -> %s\n", *method, len(examples), *outPath)
+	for _, modelName := range models {
+		modelCalibration := calibrations.Models[modelName]
+		fmt.Printf("  %s: %d intent, %d entity calibrators\n", modelName, len(modelCalibration.Intents), len(modelCalibration.Entities))
+	}
+}
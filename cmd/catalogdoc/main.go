@@ -0,0 +1,56 @@
+package main
+
+// Catalogdoc - generates the markdown documentation of the NLU intent/entity catalog
+//
+// Reads the YAML catalog file, or the NLU_*_INTENT and NLU_*_ENTITY lists when -catalog is empty.
+// With -yaml the catalog is written in the catalog file format instead, to migrate the env lists.
+
+import (
+	"eino_llm_poc/src/llm/nlu"
+	"eino_llm_poc/src/model"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+func main() {
+	catalogPath := flag.String("catalog", "", "YAML catalog file (NLU_CATALOG_FILE), empty reads the env lists")
+	outPath := flag.String("out", "", "file to write, empty writes to stdout")
+	asYAML := flag.Bool("yaml", false, "write the catalog file format instead of markdown")
+	flag.Parse()
+
+	var nluConfig model.NLUConfig
+	if err := envconfig.Process("", &nluConfig); err != nil {
+		fmt.Printf("Error reading NLU config: %v\n", err)
+		os.Exit(1)
+	}
+	if *catalogPath != "" {
+		nluConfig.CatalogFile = *catalogPath
+	}
+
+	catalog, err := nlu.NewCatalogFromConfig(&nluConfig)
+	if err != nil {
+		fmt.Printf("Error loading catalog: %v\n", err)
+		os.Exit(1)
+	}
+
+	output := []byte(catalog.Markdown())
+	if *asYAML {
+		if output, err = catalog.YAML(); err != nil {
+			fmt.Printf("Error encoding catalog: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *outPath == "" {
+		os.Stdout.Write(output)
+		return
+	}
+	if err := os.WriteFile(*outPath, output, 0o644); err != nil {
+		fmt.Printf("Error writing %s: %v\n", *outPath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %d intents and %d entities -> %s\n", len(catalog.Intents), len(catalog.Entities), *outPath)
+}
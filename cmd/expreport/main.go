@@ -0,0 +1,64 @@
+package main
+
+// Expreport - aggregates NLU experiment outcomes per variant from JSON logs
+//
+// Reads the "NLU experiment outcome" events logged with LOG_FORMAT=json and prints, per variant,
+// the parse success rate, latency and the distribution of primary intent confidences:
+//   go run ./cmd/expreport -log logs/app.log
+
+import (
+	"eino_llm_poc/src/llm/nlu"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+func main() {
+	logPath := flag.String("log", "", "JSON log file, empty reads stdin")
+	experimentName := flag.String("experiment", "", "only report this experiment")
+	flag.Parse()
+
+	var input io.Reader = os.Stdin
+	if *logPath != "" {
+		file, err := os.Open(*logPath)
+		if err != nil {
+			fmt.Printf("Error opening log: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	outcomes, err := nlu.ReadExperimentOutcomes(input)
+	if err != nil {
+		fmt.Printf("Error reading outcomes: %v\n", err)
+		os.Exit(1)
+	}
+	if *experimentName != "" {
+		filtered := outcomes[:0]
+		for _, outcome := range outcomes {
+			if outcome.Experiment == *experimentName {
+				filtered = append(filtered, outcome)
+			}
+		}
+		outcomes = filtered
+	}
+	if len(outcomes) == 0 {
+		fmt.Println("No experiment outcomes found")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EXPERIMENT\tVARIANT\tREQUESTS\tSUCCESS\tERRORS\tREPAIRED\tLATENCY MEAN\tP50\tP95\tCONF P50\tCONF 0-.2/.2-.4/.4-.6/.6-.8/.8-1")
+	for _, report := range nlu.SummarizeOutcomes(outcomes) {
+		h := report.ConfidenceHistogram
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f%%\t%.1f%%\t%.1f%%\t%.0fms\t%.0fms\t%.0fms\t%.2f\t%d/%d/%d/%d/%d\n",
+			report.Experiment, report.Variant, report.Requests,
+			report.SuccessRate*100, report.ErrorRate*100, report.RepairRate*100,
+			report.LatencyMean, report.LatencyP50, report.LatencyP95, report.ConfidenceP50,
+			h[0], h[1], h[2], h[3], h[4])
+	}
+	w.Flush()
+}
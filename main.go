@@ -2,30 +2,40 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"eino_llm_poc/src"
 	"eino_llm_poc/src/conversation"
+	"eino_llm_poc/src/llm/chatmodel"
 	"eino_llm_poc/src/llm/nlu"
 	"eino_llm_poc/src/logger"
 	"eino_llm_poc/src/model"
 
-	"github.com/cloudwego/eino-ext/components/model/openai"
+	einomodel "github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/compose"
 	"github.com/cloudwego/eino/schema"
 	"github.com/joho/godotenv"
 )
 
 type QueryInput struct {
-	CustomerID string `json:"customer_id"`
-	Query      string `json:"query"`
+	CustomerID string              `json:"customer_id"`
+	Query      string              `json:"query"`
+	Catalog    nlu.CatalogSelector `json:"catalog,omitempty"` // tenant/channel catalog, empty uses the configured one
 }
 
 type State struct {
-	History    []*schema.Message
-	CustomerID string
+	History        []*schema.Message
+	CustomerID     string
+	Query          string
+	RepairAttempts int      // repair rounds already requested for this query
+	RepairReasons  []string // set by the parser when the last output needs a repair round
+	Catalog        *nlu.ResolvedCatalog
+	Variant        *nlu.ExperimentVariant // experiment variant of the customer, nil without an experiment
+	ModelOptions   []einomodel.Option     // call options of the variant, applied to continuations too
 }
 
 type QueryOutput struct {
@@ -36,6 +46,7 @@ const (
 	NodeInputConverter = "InputConverter"
 	NodeNLUChatModel   = "NLUChatModel"
 	NodeParser         = "Parser"
+	NodeRepair         = "Repair"
 )
 
 func main() {
@@ -44,7 +55,10 @@ func main() {
 	}
 
 	ctx := context.Background()
-	apiKey := os.Getenv("OPENROUTER_API_KEY")
+	apiKey := os.Getenv("NLU_API_KEY")
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENROUTER_API_KEY")
+	}
 
 	// Load configuration from environment variables
 	config, err := src.LoadConfig()
@@ -68,18 +82,222 @@ func main() {
 		return
 	}
 
-	// Setup OpenAI model
-	chatModelNLU, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
-		APIKey:      apiKey,
-		BaseURL:     "https://openrouter.ai/api/v1",
-		Model:       config.NLUConfig.Model,
-		Temperature: &config.NLUConfig.Temperature,
-		MaxTokens:   &config.NLUConfig.MaxTokens,
-	})
+	// Load the intent/entity catalog used for prompting and validation
+	nluCatalog, err := nlu.NewCatalogFromConfig(&config.NLUConfig)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error loading NLU catalog")
+		return
+	}
+	outputMode := nlu.ResolveOutputMode(&config.NLUConfig, config.NLUConfig.Model)
+
+	// Labels differ per request with per-request catalogs, so the response schemas do not enumerate them
+	// and the parser validates against the catalog of the request instead
+	schemaCatalog := nluCatalog
+	if config.NLUConfig.CatalogDir != "" || config.NLUConfig.CatalogRedisPrefix != "" {
+		schemaCatalog = nil
+	}
+
+	// Setup the ChatModel of the configured provider, each model gets its own retries and circuit breaker
+	// Fallback models of another provider use its default base URL and NLU_<PROVIDER>_API_KEY
+	resilienceOpts := []chatmodel.ResilienceOption{
+		chatmodel.WithCallTimeout(config.NLUConfig.CallTimeout),
+		chatmodel.WithRetries(config.NLUConfig.MaxRetries, config.NLUConfig.RetryBaseDelay, config.NLUConfig.RetryMaxDelay),
+		chatmodel.WithCircuitBreaker(config.NLUConfig.BreakerThreshold, config.NLUConfig.BreakerCooldown),
+		chatmodel.WithBreakerHook(func(name, state string) {
+			logger.Warn().Str("model", name).Str("state", state).Msg("NLU circuit breaker state changed")
+		}),
+	}
+	newChatModel := func(ref chatmodel.ModelRef) (einomodel.ToolCallingChatModel, error) {
+		baseURL, key := config.NLUConfig.BaseURL, apiKey
+		if !strings.EqualFold(ref.Provider, config.NLUConfig.Provider) {
+			baseURL, key = "", os.Getenv("NLU_"+strings.ToUpper(ref.Provider)+"_API_KEY")
+		}
+		modelOpts := []chatmodel.Option{
+			chatmodel.WithAPIKey(key),
+			chatmodel.WithTemperature(config.NLUConfig.Temperature),
+			chatmodel.WithMaxTokens(config.NLUConfig.MaxTokens),
+		}
+		if outputMode == nlu.OutputModeJSON {
+			modelOpts = append(modelOpts, nlu.JSONSchemaOption(schemaCatalog))
+		}
+		chatModel, err := chatmodel.NewChatModel(ctx, ref.Provider, baseURL, ref.Model, modelOpts...)
+		if err != nil {
+			return nil, err
+		}
+		return chatmodel.NewResilientChatModel(chatModel, ref.String(), resilienceOpts...), nil
+	}
+	primaryRef := chatmodel.ModelRef{Provider: config.NLUConfig.Provider, Model: config.NLUConfig.Model}
+	primaryModel, err := newChatModel(primaryRef)
 	if err != nil {
 		logger.Error().Err(err).Msg("Error creating model")
 		return
 	}
+	logger.Info().Str("provider", config.NLUConfig.Provider).Str("model", config.NLUConfig.Model).Msg("NLU ChatModel created")
+
+	// Fallback models are shared by the chains of the primary model and the experiment variant models,
+	// they have to share its output mode
+	var fallbackCandidates []chatmodel.Candidate
+	if config.NLUConfig.FallbackModels != "" {
+		fallbackRefs, err := chatmodel.ParseModelList(config.NLUConfig.FallbackModels, config.NLUConfig.Provider)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error parsing NLU fallback models")
+			return
+		}
+		for _, ref := range fallbackRefs {
+			if nlu.ResolveOutputMode(&config.NLUConfig, ref.Model) != outputMode {
+				logger.Error().Str("model", ref.String()).Msg("Fallback model needs another output mode")
+				return
+			}
+			fallbackModel, err := newChatModel(ref)
+			if err != nil {
+				logger.Error().Str("model", ref.String()).Err(err).Msg("Error creating fallback model")
+				return
+			}
+			fallbackCandidates = append(fallbackCandidates, chatmodel.Candidate{Ref: ref, Model: fallbackModel})
+		}
+		logger.Info().Int("fallback_models", len(fallbackRefs)).Msg("NLU fallback chain created")
+	}
+
+	// Every model heads a chain of its own, which records the answering model in the message Extra
+	newChain := func(first chatmodel.Candidate) (einomodel.ToolCallingChatModel, error) {
+		candidates := append([]chatmodel.Candidate{first}, fallbackCandidates...)
+		return chatmodel.NewFallbackChatModel(candidates, chatmodel.WithBudgetWindow(config.NLUConfig.TokenBudgetWindow))
+	}
+	providerModel, err := newChain(chatmodel.Candidate{Ref: primaryRef, Model: primaryModel, TokenBudget: config.NLUConfig.TokenBudget})
+	if err != nil {
+		logger.Error().Err(err).Msg("Error creating NLU fallback chain")
+		return
+	}
+
+	// Load confidence calibrators fitted offline with cmd/calibrate
+	var calibrations *nlu.Calibrations
+	if config.NLUConfig.CalibrationFile != "" {
+		calibrations, err = nlu.LoadCalibrations(config.NLUConfig.CalibrationFile)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error loading NLU calibration")
+			return
+		}
+	}
+
+	// Load the product catalog entity values are linked to
+	var entityLinker *nlu.EntityLinker
+	if config.NLUConfig.ProductCatalogFile != "" {
+		productCatalog, err := nlu.LoadProductCatalog(config.NLUConfig.ProductCatalogFile)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error loading product catalog")
+			return
+		}
+		entityLinker = nlu.NewEntityLinker(productCatalog)
+		entityLinker.Threshold = config.NLUConfig.LinkThreshold
+		entityLinker.MaxCandidates = config.NLUConfig.LinkMaxCandidates
+		logger.Info().Int("products", len(productCatalog.Products)).Msg("Product catalog loaded")
+	}
+
+	// Load the labeled example bank few-shot examples are retrieved from
+	var exampleBank *nlu.ExampleBank
+	if config.NLUConfig.ExampleBankFile != "" {
+		exampleBank, err = nlu.LoadExampleBank(config.NLUConfig.ExampleBankFile)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error loading example bank")
+			return
+		}
+		logger.Info().Int("examples", len(exampleBank.Examples)).Msg("Example bank loaded")
+	}
+
+	// Setup NLU output parser validated against the configured intent/entity catalog
+	// Custom tuple types registered here are parsed and described in the system prompt
+	tupleRegistry := nlu.NewTupleRegistry()
+	nluProcessor := nlu.NewNLUProcessor(
+		nlu.WithTupleRegistry(tupleRegistry),
+		nlu.WithParseMode(nlu.ParseMode(config.NLUConfig.ParseMode)),
+		nlu.WithCatalog(nluCatalog),
+		nlu.WithFormatRecovery(config.NLUConfig.FormatRecovery),
+		nlu.WithOutputMode(outputMode),
+		nlu.WithCalibration(calibrations, config.NLUConfig.Model),
+		nlu.WithImportanceScorer(nlu.NewWeightedScorer(nlu.ImportanceWeightsFromConfig(&config.NLUConfig))),
+		nlu.WithMaxContinuations(config.NLUConfig.MaxContinuations),
+		nlu.WithEntityNormalization(config.NLUConfig.EntityNormalization, config.NLUConfig.DefaultCurrency),
+		nlu.WithEntityLinker(entityLinker),
+		nlu.WithPromptVersion(config.NLUConfig.PromptVersion),
+	)
+
+	// Resolve the catalog of each request from NLU_CATALOG_DIR and Redis, falling back to nluCatalog
+	// Rendered system prompts are cached with the catalog, the fallback prompt is rendered here so
+	// an unknown prompt version fails at startup
+	var catalogSources []nlu.CatalogSource
+	if config.NLUConfig.CatalogDir != "" {
+		catalogSources = append(catalogSources, &nlu.FileCatalogSource{Dir: config.NLUConfig.CatalogDir})
+	}
+	if config.NLUConfig.CatalogRedisPrefix != "" {
+		redisCatalogs, err := nlu.NewRedisCatalogSource(ctx, os.Getenv("REDIS_URL"), config.NLUConfig.CatalogRedisPrefix)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error connecting to the catalog store")
+			return
+		}
+		catalogSources = append(catalogSources, redisCatalogs)
+	}
+	renderPrompt := func(catalog *nlu.Catalog, version, examples string) (string, error) {
+		promptConfig := config.NLUConfig
+		if version != "" {
+			promptConfig.PromptVersion = version
+		}
+		return nlu.GetSystemTemplateWithExamples(&promptConfig, outputMode, catalog, tupleRegistry, examples)
+	}
+	catalogResolver, err := nlu.NewCatalogResolver(nluCatalog, renderPrompt, config.NLUConfig.CatalogCacheTTL, config.NLUConfig.CatalogCacheSize, catalogSources...)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error loading NLU system prompt")
+		return
+	}
+
+	// Load the prompt/model experiment, every variant's prompt is rendered once to fail at startup
+	// Variants share the output mode, their model and temperature are call options; a variant model
+	// is routed to a chain of its own so it does not share the primary model's breaker and budget
+	routes := make(map[string]einomodel.ToolCallingChatModel)
+	var experiment *nlu.Experiment
+	if config.NLUConfig.ExperimentFile != "" {
+		experiment, err = nlu.LoadExperiment(config.NLUConfig.ExperimentFile)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error loading NLU experiment")
+			return
+		}
+		fallbackCatalog, _ := catalogResolver.Resolve(ctx, nlu.CatalogSelector{})
+		for _, variant := range experiment.Variants {
+			if variant.Model != "" && nlu.ResolveOutputMode(&config.NLUConfig, variant.Model) != outputMode {
+				logger.Error().Str("variant", variant.Name).Str("model", variant.Model).Msg("Experiment variant needs another output mode")
+				return
+			}
+			if _, err := catalogResolver.SystemPrompt(fallbackCatalog, variant.PromptVersion); err != nil {
+				logger.Error().Str("variant", variant.Name).Err(err).Msg("Error loading experiment variant prompt")
+				return
+			}
+			if variant.Model == "" || variant.Model == config.NLUConfig.Model || routes[variant.Model] != nil {
+				continue
+			}
+			variantRef := chatmodel.ModelRef{Provider: config.NLUConfig.Provider, Model: variant.Model}
+			variantModel, err := newChatModel(variantRef)
+			if err != nil {
+				logger.Error().Str("variant", variant.Name).Str("model", variant.Model).Err(err).Msg("Error creating experiment variant model")
+				return
+			}
+			routes[variant.Model], err = newChain(chatmodel.Candidate{Ref: variantRef, Model: variantModel})
+			if err != nil {
+				logger.Error().Str("variant", variant.Name).Err(err).Msg("Error creating experiment variant chain")
+				return
+			}
+		}
+		logger.Info().Str("experiment", experiment.Name).Int("variants", len(experiment.Variants)).Msg("NLU experiment loaded")
+	}
+	if len(routes) > 0 {
+		providerModel = chatmodel.NewRouterChatModel(providerModel, routes)
+	}
+	var chatModelNLU einomodel.BaseChatModel = providerModel
+	if outputMode == nlu.OutputModeTools {
+		chatModelNLU, err = providerModel.WithTools(nlu.NLUToolInfos(schemaCatalog))
+		if err != nil {
+			logger.Error().Err(err).Msg("Error binding NLU tools")
+			return
+		}
+	}
 
 	g := compose.NewGraph[QueryInput, QueryOutput](
 		compose.WithGenLocalState(func(ctx context.Context) *State {
@@ -99,24 +317,51 @@ func main() {
 
 		logger.Debug().Str("customer_id", input.CustomerID).Msg("Retrieved conversation context from Redis")
 
-		// Generate system prompt
-		systemPrompt := nlu.GetSystemTemplateProcessed(&config.NLUConfig)
+		resolved, err := catalogResolver.Resolve(ctx, input.Catalog)
+		if err != nil {
+			logger.Error().Str("customer_id", input.CustomerID).Str("catalog", input.Catalog.String()).Err(err).Msg("Error resolving NLU catalog")
+			return nil, err
+		}
+		logger.Debug().Str("customer_id", input.CustomerID).Str("catalog", resolved.Key).Msg("Resolved NLU catalog")
+
+		// Sticky assignment, the same customer always gets the same variant
+		variant := experiment.Assign(input.CustomerID)
+		promptVersion := ""
+		if variant != nil {
+			promptVersion = variant.PromptVersion
+			logger.Debug().Str("customer_id", input.CustomerID).Str("variant", variant.Name).Msg("Assigned experiment variant")
+		}
+		// Retrieved examples replace the static examples of the prompt, which remain the fallback
+		// when no example bank is configured or nothing similar is found
+		fewShot := ""
+		if examples := exampleBank.Retrieve(input.Query, config.NLUConfig.FewShotK); len(examples) > 0 {
+			fewShot = nluProcessor.ForCatalog(resolved.Catalog).RenderFewShot(examples)
+			selected := make([]string, len(examples))
+			for i, example := range examples {
+				selected[i] = example.Text
+			}
+			logger.Debug().Str("customer_id", input.CustomerID).Strs("few_shot_examples", selected).Msg("Retrieved few-shot examples")
+		}
+		systemPrompt, err := catalogResolver.SystemPromptWithExamples(resolved, promptVersion, fewShot)
+		if err != nil {
+			return nil, err
+		}
 
 		// Create messages with customerID in Extra
-		messages := []*schema.Message{
-			schema.SystemMessage(systemPrompt),
-			schema.UserMessage(conversationCtx),
-		}
+		messages := []*schema.Message{schema.SystemMessage(systemPrompt), schema.UserMessage(conversationCtx)}
 
 		for _, msg := range messages {
 			if msg.Extra == nil {
 				msg.Extra = make(map[string]interface{})
 			}
 			msg.Extra["customerID"] = input.CustomerID
+			msg.Extra["query"] = input.Query
+			msg.Extra["catalog"] = resolved
+			msg.Extra["variant"] = variant
 		}
 		logger.Debug().Str("customer_id", input.CustomerID).Int("message_count", len(messages)).Msg("Generated input converter messages")
 
-		// Pretty print messages for debugging
+		// Pretty print messages for debugging, Extra holds the whole resolved catalog so only its key is logged
 		for i, msg := range messages {
 			// Print full content if not too long
 			content := msg.Content
@@ -124,7 +369,8 @@ func main() {
 				Str("customer_id", input.CustomerID).
 				Int("message_index", i).
 				Str("role", string(msg.Role)).
-				Interface("extra", msg.Extra).
+				Str("selector", input.Catalog.String()).
+				Str("catalog", resolved.Key).
 				Msg("Message: " + content)
 		}
 		return messages, nil
@@ -136,6 +382,16 @@ func main() {
 			if cid, ok := in[0].Extra["customerID"].(string); ok {
 				state.CustomerID = cid
 			}
+			if query, ok := in[0].Extra["query"].(string); ok {
+				state.Query = query
+			}
+			if resolved, ok := in[0].Extra["catalog"].(*nlu.ResolvedCatalog); ok {
+				state.Catalog = resolved
+			}
+			if variant, ok := in[0].Extra["variant"].(*nlu.ExperimentVariant); ok {
+				state.Variant = variant
+				state.ModelOptions = variant.ModelOptions()
+			}
 		}
 		state.History = append(state.History, in...)
 		return state.History, nil
@@ -145,30 +401,130 @@ func main() {
 		customerID := state.CustomerID
 		logger.Debug().Str("customer_id", customerID).Int("response_length", len(out.Content)).Msg("Received model response")
 
-		// Save response to Redis
-		if err := messagesManager.SaveResponse(ctx, customerID, out.Content); err != nil {
-			logger.Warn().Str("customer_id", customerID).Err(err).Msg("Failed to save response to Redis")
-		} else {
-			logger.Debug().Str("customer_id", customerID).Msg("Successfully saved response to Redis")
+		// Continue output cut off by NLU_MAX_TOKENS and stitch the parts before parsing
+		if nluProcessor.IsTruncated(out) {
+			logger.Warn().Str("customer_id", customerID).Msg("NLU output truncated, requesting continuation")
+			stitched, err := nluProcessor.ContinueTruncated(ctx, chatModelNLU, state.History, out, state.ModelOptions...)
+			if err != nil {
+				return nil, err
+			}
+			out = stitched
 		}
-		// Update history
+
+		// Update history, the response is saved to Redis by the parser once no repair is needed
 		state.History = append(state.History, out)
 		return out, nil
 	}
 
 	parserNLU := compose.InvokableLambda(func(ctx context.Context, resp *schema.Message) (QueryOutput, error) {
-		result, err := nlu.ParseNLUResponse(resp.Content)
-		if err != nil {
-			return QueryOutput{}, err
+		// Validate against the catalog the request was prompted with
+		processor := nluProcessor
+		stateErr := compose.ProcessState(ctx, func(ctx context.Context, state *State) error {
+			if state.Catalog != nil {
+				processor = nluProcessor.ForCatalog(state.Catalog.Catalog)
+			}
+			return nil
+		})
+		if stateErr != nil {
+			return QueryOutput{}, stateErr
 		}
+
+		result, err := processor.ParseMessage(resp)
 		if result == nil {
-			return QueryOutput{}, fmt.Errorf("received nil result from ParseNLUResponse")
+			if err == nil {
+				err = fmt.Errorf("received nil result from ParseMessage")
+			}
+			return QueryOutput{}, err
+		}
+
+		// Request a repair round for unusable output while attempts remain
+		repairing := false
+		stateErr = compose.ProcessState(ctx, func(ctx context.Context, state *State) error {
+			result.ParsingMetadata["repair_attempts"] = state.RepairAttempts
+			if state.Catalog != nil {
+				result.ParsingMetadata["catalog"] = state.Catalog.Key
+			}
+			if state.Variant != nil {
+				result.Variant = state.Variant.Name
+				if state.Variant.PromptVersion != "" {
+					result.ParsingMetadata["prompt_version"] = state.Variant.PromptVersion
+				}
+			}
+			reasons := nlu.RepairReasons(result)
+			if len(reasons) > 0 && state.RepairAttempts < config.NLUConfig.MaxRepairAttempts {
+				state.RepairReasons = reasons
+				repairing = true
+				return nil
+			}
+			state.RepairReasons = nil
+
+			// Save the final response to Redis, tools mode responses carry their result in tool calls
+			content := resp.Content
+			if content == "" && len(resp.ToolCalls) > 0 {
+				content = nlu.ToolCallsText(resp)
+			}
+			if err := messagesManager.SaveResponse(ctx, state.CustomerID, content); err != nil {
+				logger.Warn().Str("customer_id", state.CustomerID).Err(err).Msg("Failed to save response to Redis")
+			} else {
+				logger.Debug().Str("customer_id", state.CustomerID).Msg("Successfully saved response to Redis")
+			}
+
+			// Verify entity spans and languages against the analysed message, not the conversation context
+			nlu.VerifyEntitySpans(result, state.Query)
+			nlu.VerifyLanguages(result, state.Query)
+			return nil
+		})
+		if stateErr != nil {
+			return QueryOutput{}, stateErr
+		}
+		if repairing {
+			return QueryOutput{Result: *result}, nil
+		}
+
+		if err != nil {
+			var parseErr *nlu.ParseError
+			if errors.As(err, &parseErr) {
+				logger.Warn().Interface("failures", parseErr.Failures).Bool("completion_seen", parseErr.CompletionSeen).Msg("Strict NLU parsing failed")
+			}
+			return QueryOutput{}, err
 		}
 		return QueryOutput{
 			Result: *result,
 		}, nil
 	})
 
+	// Repair turns the parse problems into a follow-up request for the ChatModel
+	repairNLU := compose.InvokableLambda(func(ctx context.Context, out QueryOutput) ([]*schema.Message, error) {
+		var messages []*schema.Message
+		err := compose.ProcessState(ctx, func(ctx context.Context, state *State) error {
+			state.RepairAttempts++
+			logger.Warn().Str("customer_id", state.CustomerID).
+				Int("attempt", state.RepairAttempts).
+				Strs("reasons", state.RepairReasons).
+				Msg("Requesting NLU output repair")
+
+			var brokenOutput *schema.Message
+			if len(state.History) > 0 {
+				brokenOutput = state.History[len(state.History)-1]
+			}
+			messages = nluProcessor.RepairMessages(brokenOutput, state.RepairReasons)
+			return nil
+		})
+		return messages, err
+	})
+
+	// Repeat the parser decision: back to the ChatModel through Repair, or done
+	repairBranch := compose.NewGraphBranch(func(ctx context.Context, out QueryOutput) (string, error) {
+		next := compose.END
+		err := compose.ProcessState(ctx, func(ctx context.Context, state *State) error {
+			if len(state.RepairReasons) > 0 {
+				next = NodeRepair
+			}
+			return nil
+		})
+		return next, err
+	}, map[string]bool{NodeRepair: true, compose.END: true})
+
 	// Add nodes to graph
 	g.AddLambdaNode(NodeInputConverter, inputConverterNLU)
 	g.AddChatModelNode(NodeNLUChatModel, chatModelNLU,
@@ -176,15 +532,17 @@ func main() {
 		compose.WithStatePostHandler(postHandlerNLU),
 	)
 	g.AddLambdaNode(NodeParser, parserNLU)
+	g.AddLambdaNode(NodeRepair, repairNLU)
 
 	// Wire the nodes
 	g.AddEdge(compose.START, NodeInputConverter)
 	g.AddEdge(NodeInputConverter, NodeNLUChatModel)
 	g.AddEdge(NodeNLUChatModel, NodeParser)
-	g.AddEdge(NodeParser, compose.END)
+	g.AddBranch(NodeParser, repairBranch)
+	g.AddEdge(NodeRepair, NodeNLUChatModel)
 
-	// Compile graph
-	runnable, err := g.Compile(ctx)
+	// Compile graph, each repair round runs Repair -> ChatModel -> Parser
+	runnable, err := g.Compile(ctx, compose.WithMaxRunSteps(10+3*config.NLUConfig.MaxRepairAttempts))
 	if err != nil {
 		logger.Error().Err(err).Msg("Error compiling graph")
 		return
@@ -197,6 +555,7 @@ func main() {
 		{CustomerID: "1111", Query: "ราคาเท่าไหร่"},
 		{CustomerID: "1111", Query: "แพงจัง"},
 		{CustomerID: "1111", Query: "ขอบคุณนะครับ"},
+		{CustomerID: "2222", Query: "อยากซื้อรองเท้า", Catalog: nlu.CatalogSelector{Tenant: "shop1", Channel: "line"}},
 	}
 
 	logger.Info().Int("total_inputs", len(inputs)).Msg("Starting batch processing")
@@ -205,8 +564,23 @@ func main() {
 		fmt.Printf("\n=== Processing Input %d ===\n", i+1)
 		fmt.Printf("Input: CustomerID=%s, Query=%s\n", input.CustomerID, input.Query)
 
+		// The input converter assigns the same variant for the prompt, its model and temperature apply here
+		var invokeOpts []compose.Option
+		variant := experiment.Assign(input.CustomerID)
+		if modelOpts := variant.ModelOptions(); len(modelOpts) > 0 {
+			invokeOpts = append(invokeOpts, compose.WithChatModelOption(modelOpts...))
+		}
+
 		start := time.Now()
-		result, err := runnable.Invoke(ctx, input)
+		result, err := runnable.Invoke(ctx, input, invokeOpts...)
+		if variant != nil {
+			var response *model.NLUResponse
+			if err == nil {
+				response = &result.Result
+			}
+			outcome := nlu.NewExperimentOutcome(experiment, variant, input.CustomerID, response, time.Since(start))
+			logger.Info().Interface("outcome", outcome).Msg(nlu.ExperimentOutcomeMessage)
+		}
 		if err != nil {
 			logger.Error().Str("customer_id", input.CustomerID).Err(err).Msg("Error processing input")
 			continue
@@ -247,6 +621,7 @@ func main() {
 				Str("entity_value", entity.Value).
 				Float64("entity_confidence", entity.Confidence).
 				Interface("entity_position", entity.Position).
+				Interface("entity_normalized", entity.Normalized).
 				Interface("entity_metadata", entity.Metadata).
 				Msg("Entity details")
 		}
@@ -261,6 +636,18 @@ func main() {
 				Interface("language_metadata", language.Metadata).
 				Msg("Language details")
 		}
+
+		// Log detailed aspect sentiments
+		for i, aspect := range result.Result.AspectSentiments {
+			logger.Debug().Str("customer_id", input.CustomerID).
+				Int("aspect_index", i).
+				Str("aspect", aspect.Aspect).
+				Str("aspect_span", aspect.Span).
+				Str("aspect_label", aspect.Label).
+				Float64("aspect_confidence", aspect.Confidence).
+				Interface("aspect_metadata", aspect.Metadata).
+				Msg("Aspect sentiment details")
+		}
 	}
 
 	logger.Info().Msg("Batch processing completed")
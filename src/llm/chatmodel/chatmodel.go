@@ -0,0 +1,228 @@
+package chatmodel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino-ext/components/model/ark"
+	"github.com/cloudwego/eino-ext/components/model/deepseek"
+	"github.com/cloudwego/eino-ext/components/model/ollama"
+	"github.com/cloudwego/eino-ext/components/model/openai"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/ollama/ollama/api"
+	arkmodel "github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
+)
+
+// Provider names accepted by NewChatModel and NLU_PROVIDER
+const (
+	// ProviderOpenAI is any OpenAI-compatible API, OpenRouter unless a base URL is given
+	ProviderOpenAI   = "openai"
+	ProviderOllama   = "ollama"
+	ProviderDeepSeek = "deepseek"
+	ProviderArk      = "ark"
+)
+
+// Default base URLs used when none is given, DeepSeek and Ark use their SDK defaults
+const (
+	DefaultOpenAIBaseURL = "https://openrouter.ai/api/v1"
+	DefaultOllamaBaseURL = "http://localhost:11434"
+)
+
+// Providers lists the supported provider names
+var Providers = []string{ProviderOpenAI, ProviderOllama, ProviderDeepSeek, ProviderArk}
+
+// options holds the provider-independent ChatModel settings
+type options struct {
+	apiKey      string
+	temperature *float32
+	maxTokens   *int
+	timeout     time.Duration
+
+	jsonSchemaName        string
+	jsonSchemaDescription string
+	jsonSchema            *openapi3.Schema
+}
+
+// Option configures the ChatModel built by NewChatModel
+type Option func(*options)
+
+// WithAPIKey sets the API key, Ollama ignores it
+func WithAPIKey(apiKey string) Option {
+	return func(o *options) {
+		o.apiKey = apiKey
+	}
+}
+
+// WithTemperature sets the sampling temperature
+func WithTemperature(temperature float32) Option {
+	return func(o *options) {
+		o.temperature = &temperature
+	}
+}
+
+// WithMaxTokens limits the generated tokens
+func WithMaxTokens(maxTokens int) Option {
+	return func(o *options) {
+		o.maxTokens = &maxTokens
+	}
+}
+
+// WithTimeout sets the HTTP request timeout, zero for none
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithJSONSchema constrains the output to JSON matching schema
+// OpenAI-compatible APIs, Ollama and Ark enforce the schema; DeepSeek only supports
+// plain JSON objects, so the schema has to be described in the prompt as well
+func WithJSONSchema(name, description string, schema *openapi3.Schema) Option {
+	return func(o *options) {
+		o.jsonSchemaName = name
+		o.jsonSchemaDescription = description
+		o.jsonSchema = schema
+	}
+}
+
+// NewChatModel builds the ChatModel of a provider, an empty baseURL uses the provider default
+func NewChatModel(ctx context.Context, provider, baseURL, modelName string, opts ...Option) (model.ToolCallingChatModel, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if modelName == "" {
+		return nil, fmt.Errorf("model name is required")
+	}
+
+	switch strings.ToLower(provider) {
+	case ProviderOpenAI, "":
+		return newOpenAI(ctx, baseURL, modelName, o)
+	case ProviderOllama:
+		return newOllama(ctx, baseURL, modelName, o)
+	case ProviderDeepSeek:
+		return newDeepSeek(ctx, baseURL, modelName, o)
+	case ProviderArk:
+		return newArk(ctx, baseURL, modelName, o)
+	default:
+		return nil, fmt.Errorf("unknown model provider %q, expected one of %s", provider, strings.Join(Providers, ", "))
+	}
+}
+
+// newOpenAI builds an OpenAI-compatible ChatModel
+func newOpenAI(ctx context.Context, baseURL, modelName string, o *options) (model.ToolCallingChatModel, error) {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIBaseURL
+	}
+	config := &openai.ChatModelConfig{
+		APIKey:      o.apiKey,
+		BaseURL:     baseURL,
+		Model:       modelName,
+		Temperature: o.temperature,
+		MaxTokens:   o.maxTokens,
+		HTTPClient:  newHTTPClient(o.timeout),
+	}
+	if o.jsonSchema != nil {
+		config.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:        o.jsonSchemaName,
+				Description: o.jsonSchemaDescription,
+				Schema:      o.jsonSchema,
+				// Metadata objects are free-form, which strict schemas do not allow
+				Strict: false,
+			},
+		}
+	}
+	chatModel, err := openai.NewChatModel(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create openai model: %v", err)
+	}
+	return chatModel, nil
+}
+
+// newOllama builds a ChatModel for a local or remote Ollama server
+func newOllama(ctx context.Context, baseURL, modelName string, o *options) (model.ToolCallingChatModel, error) {
+	if baseURL == "" {
+		baseURL = DefaultOllamaBaseURL
+	}
+	config := &ollama.ChatModelConfig{
+		BaseURL:    baseURL,
+		Model:      modelName,
+		HTTPClient: newHTTPClient(o.timeout),
+		Options:    &api.Options{},
+	}
+	if o.temperature != nil {
+		config.Options.Temperature = *o.temperature
+	}
+	if o.maxTokens != nil {
+		config.Options.NumPredict = *o.maxTokens
+	}
+	if o.jsonSchema != nil {
+		format, err := json.Marshal(o.jsonSchema)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode JSON schema: %v", err)
+		}
+		config.Format = format
+	}
+	chatModel, err := ollama.NewChatModel(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ollama model: %v", err)
+	}
+	return chatModel, nil
+}
+
+// newDeepSeek builds a ChatModel for the DeepSeek API
+func newDeepSeek(ctx context.Context, baseURL, modelName string, o *options) (model.ToolCallingChatModel, error) {
+	config := &deepseek.ChatModelConfig{
+		APIKey:     o.apiKey,
+		BaseURL:    baseURL,
+		Model:      modelName,
+		HTTPClient: newHTTPClient(o.timeout),
+	}
+	if o.temperature != nil {
+		config.Temperature = *o.temperature
+	}
+	if o.maxTokens != nil {
+		config.MaxTokens = *o.maxTokens
+	}
+	if o.jsonSchema != nil {
+		config.ResponseFormatType = deepseek.ResponseFormatTypeJSONObject
+	}
+	chatModel, err := deepseek.NewChatModel(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deepseek model: %v", err)
+	}
+	return chatModel, nil
+}
+
+// newArk builds a ChatModel for Volcengine Ark, modelName is the endpoint ID
+func newArk(ctx context.Context, baseURL, modelName string, o *options) (model.ToolCallingChatModel, error) {
+	config := &ark.ChatModelConfig{
+		APIKey:      o.apiKey,
+		BaseURL:     baseURL,
+		Model:       modelName,
+		Temperature: o.temperature,
+		MaxTokens:   o.maxTokens,
+		HTTPClient:  newHTTPClient(o.timeout),
+	}
+	if o.jsonSchema != nil {
+		config.ResponseFormat = &ark.ResponseFormat{
+			Type: arkmodel.ResponseFormatJSONSchema,
+			JSONSchema: &arkmodel.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:        o.jsonSchemaName,
+				Description: o.jsonSchemaDescription,
+				Schema:      o.jsonSchema,
+			},
+		}
+	}
+	chatModel, err := ark.NewChatModel(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ark model: %v", err)
+	}
+	return chatModel, nil
+}
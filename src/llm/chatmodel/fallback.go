@@ -0,0 +1,370 @@
+package chatmodel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	"github.com/ollama/ollama/api"
+	arkmodel "github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
+)
+
+// Message Extra keys set by FallbackChatModel on its answer
+const (
+	ExtraAnsweredBy       = "nlu_answered_by"
+	ExtraFallbackAttempts = "nlu_fallback_attempts"
+)
+
+// DefaultBudgetWindow is the period token budgets are counted over
+const DefaultBudgetWindow = time.Hour
+
+// retriableStatusCodes are the HTTP status codes providers report for overload and outages
+var retriableStatusCodes = map[int]bool{
+	408: true, 409: true, 425: true, 429: true, 500: true, 502: true, 503: true, 504: true,
+	520: true, 522: true, 524: true, 529: true,
+}
+
+// providerStatus matches the HTTP status in the error messages of the provider SDKs, e.g.
+// "error, status code: 503" of OpenAI, "HTTP 429" of DeepSeek and "Error code: 500" of Ark;
+// a number elsewhere in the message, like a token count, is not a status
+var providerStatus = regexp.MustCompile(`(?:status code|error code|\bhttp)[:\s]\s*(\d{3})\b`)
+
+// transientMessages are error fragments of rate limits, overload and network failures,
+// which the same model may serve when retried later
+var transientMessages = []string{
+	"rate limit", "overloaded", "timeout", "timed out", "temporarily unavailable", "service unavailable",
+	"connection refused", "connection reset", "no such host", "unexpected eof",
+}
+
+// capacityMessages are error fragments of exceeded context, token or quota limits,
+// which retrying the same model does not fix but another model may serve
+var capacityMessages = []string{
+	"context length", "context_length", "maximum context", "too many tokens", "token limit", "quota",
+}
+
+// ModelRef names a model of a provider
+type ModelRef struct {
+	Provider string
+	Model    string
+}
+
+// String returns the "provider:model" label of the model
+func (r ModelRef) String() string {
+	return r.Provider + ":" + r.Model
+}
+
+// ParseModelList parses a comma-separated model list as in NLU_FALLBACK_MODELS
+// Entries are "provider:model" or a model of defaultProvider, e.g. "openai/gpt-4o-mini, ollama:llama3.1";
+// a prefix that is not a provider name is part of the model name, as in "vendor/model:free"
+func ParseModelList(list, defaultProvider string) ([]ModelRef, error) {
+	var refs []ModelRef
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ref := ModelRef{Provider: defaultProvider, Model: item}
+		if prefix, modelName, ok := strings.Cut(item, ":"); ok && isProvider(prefix) {
+			ref = ModelRef{Provider: strings.ToLower(prefix), Model: strings.TrimSpace(modelName)}
+		}
+		if ref.Model == "" {
+			return nil, fmt.Errorf("model list entry %q has no model name", item)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// isProvider reports whether name is a supported provider
+func isProvider(name string) bool {
+	for _, provider := range Providers {
+		if strings.EqualFold(name, provider) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRetriable reports whether another model may succeed where err failed:
+// transient errors, an open circuit breaker and exceeded token limits
+func IsRetriable(err error) bool {
+	if IsTransient(err) || errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+	return err != nil && containsAny(strings.ToLower(err.Error()), capacityMessages)
+}
+
+// IsTransient reports whether retrying the same model later may succeed where err failed:
+// timeouts, network failures, rate limits, overload and 5xx responses
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if status, ok := statusCode(err); ok {
+		return retriableStatusCodes[status]
+	}
+	return containsAny(strings.ToLower(err.Error()), transientMessages)
+}
+
+// statusCode returns the HTTP status the provider answered err with, from the typed errors of
+// the SDKs that keep them and from the error message of the others
+func statusCode(err error) (int, bool) {
+	var ollamaErr api.StatusError
+	if errors.As(err, &ollamaErr) {
+		return ollamaErr.StatusCode, true
+	}
+	var arkAPIErr *arkmodel.APIError
+	if errors.As(err, &arkAPIErr) {
+		return arkAPIErr.HTTPStatusCode, true
+	}
+	var arkRequestErr *arkmodel.RequestError
+	if errors.As(err, &arkRequestErr) && arkRequestErr.HTTPStatusCode > 0 {
+		return arkRequestErr.HTTPStatusCode, true
+	}
+	match := providerStatus.FindStringSubmatch(strings.ToLower(err.Error()))
+	if match == nil {
+		return 0, false
+	}
+	status, convErr := strconv.Atoi(match[1])
+	return status, convErr == nil
+}
+
+// containsAny reports whether s contains one of the fragments
+func containsAny(s string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if strings.Contains(s, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Candidate is one model of a fallback chain
+type Candidate struct {
+	Ref   ModelRef
+	Model model.ToolCallingChatModel
+	// TokenBudget is the number of tokens the model may use per budget window, 0 for unlimited
+	TokenBudget int
+}
+
+// FallbackAttempt is a model of the chain that did not answer and why
+type FallbackAttempt struct {
+	Model string `json:"model"`
+	Error string `json:"error"`
+}
+
+// FallbackChatModel tries its candidates in order until one answers
+//
+// A candidate is skipped when its token budget for the current window is used up and left for
+// the next one on retriable errors; other errors are returned as they are. The last candidate is
+// tried even over budget, so order the chain from preferred to cheapest. The answering model is
+// recorded in the message Extra under ExtraAnsweredBy, the skipped ones under ExtraFallbackAttempts.
+type FallbackChatModel struct {
+	candidates []Candidate
+	retriable  func(error) bool
+	budgets    *tokenBudgets
+}
+
+var _ model.ToolCallingChatModel = (*FallbackChatModel)(nil)
+
+// FallbackOption configures a FallbackChatModel
+type FallbackOption func(*FallbackChatModel)
+
+// WithBudgetWindow sets the period token budgets are counted over
+func WithBudgetWindow(window time.Duration) FallbackOption {
+	return func(f *FallbackChatModel) {
+		if window > 0 {
+			f.budgets.window = window
+		}
+	}
+}
+
+// WithRetriable replaces IsRetriable as the test for falling back to the next model
+func WithRetriable(retriable func(error) bool) FallbackOption {
+	return func(f *FallbackChatModel) {
+		if retriable != nil {
+			f.retriable = retriable
+		}
+	}
+}
+
+// NewFallbackChatModel creates a chain over the candidates, most preferred first
+func NewFallbackChatModel(candidates []Candidate, opts ...FallbackOption) (*FallbackChatModel, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("fallback chain needs at least one model")
+	}
+	f := &FallbackChatModel{
+		candidates: candidates,
+		retriable:  IsRetriable,
+		budgets:    &tokenBudgets{window: DefaultBudgetWindow, used: make(map[string]int)},
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f, nil
+}
+
+// Generate returns the answer of the first candidate that succeeds
+func (f *FallbackChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	var attempts []FallbackAttempt
+	for i, candidate := range f.candidates {
+		name, candidateOpts, ok := f.prepare(i, opts)
+		if !ok {
+			attempts = append(attempts, FallbackAttempt{Model: name, Error: "token budget exhausted"})
+			continue
+		}
+		out, err := candidate.Model.Generate(ctx, input, candidateOpts...)
+		if err == nil {
+			f.budgets.add(name, out)
+			tagAnswer(out, name, attempts)
+			return out, nil
+		}
+		if !f.shouldFallBack(ctx, i, err) {
+			return nil, f.chainError(name, attempts, err)
+		}
+		attempts = append(attempts, FallbackAttempt{Model: name, Error: err.Error()})
+	}
+	return nil, fmt.Errorf("no model of the fallback chain answered: %v", attempts)
+}
+
+// Stream returns the stream of the first candidate that starts one
+// Errors after the stream started are not retried, the output may already be consumed
+func (f *FallbackChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	var attempts []FallbackAttempt
+	for i, candidate := range f.candidates {
+		name, candidateOpts, ok := f.prepare(i, opts)
+		if !ok {
+			attempts = append(attempts, FallbackAttempt{Model: name, Error: "token budget exhausted"})
+			continue
+		}
+		stream, err := candidate.Model.Stream(ctx, input, candidateOpts...)
+		if err == nil {
+			first := true
+			return schema.StreamReaderWithConvert(stream, func(chunk *schema.Message) (*schema.Message, error) {
+				f.budgets.add(name, chunk)
+				if first {
+					first = false
+					tagAnswer(chunk, name, attempts)
+				}
+				return chunk, nil
+			}), nil
+		}
+		if !f.shouldFallBack(ctx, i, err) {
+			return nil, f.chainError(name, attempts, err)
+		}
+		attempts = append(attempts, FallbackAttempt{Model: name, Error: err.Error()})
+	}
+	return nil, fmt.Errorf("no model of the fallback chain answered: %v", attempts)
+}
+
+// WithTools binds the tools to every candidate, the copy shares the token budgets
+func (f *FallbackChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	candidates := make([]Candidate, len(f.candidates))
+	for i, candidate := range f.candidates {
+		withTools, err := candidate.Model.WithTools(tools)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", candidate.Ref, err)
+		}
+		candidate.Model = withTools
+		candidates[i] = candidate
+	}
+	return &FallbackChatModel{candidates: candidates, retriable: f.retriable, budgets: f.budgets}, nil
+}
+
+// prepare returns the label and call options of candidate i, ok is false when it is over budget
+// A model chosen with a call option applies to the first candidate only, the others keep their own
+func (f *FallbackChatModel) prepare(i int, opts []model.Option) (string, []model.Option, bool) {
+	candidate := f.candidates[i]
+	ref := candidate.Ref
+	if i == 0 {
+		if common := model.GetCommonOptions(&model.Options{}, opts...); common.Model != nil && *common.Model != "" {
+			ref.Model = *common.Model
+		}
+	} else {
+		opts = append(append([]model.Option{}, opts...), model.WithModel(candidate.Ref.Model))
+	}
+	name := ref.String()
+	last := i == len(f.candidates)-1
+	return name, opts, last || !f.budgets.exhausted(name, candidate.TokenBudget)
+}
+
+// shouldFallBack reports whether the error of candidate i leaves the request to the next candidate
+func (f *FallbackChatModel) shouldFallBack(ctx context.Context, i int, err error) bool {
+	return ctx.Err() == nil && i < len(f.candidates)-1 && f.retriable(err)
+}
+
+// chainError wraps the error of the model the chain stopped at
+func (f *FallbackChatModel) chainError(name string, attempts []FallbackAttempt, err error) error {
+	if len(attempts) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s failed after falling back from %d model(s): %w", name, len(attempts), err)
+}
+
+// tagAnswer records the answering model and the skipped ones in the message Extra
+func tagAnswer(message *schema.Message, name string, attempts []FallbackAttempt) {
+	if message == nil {
+		return
+	}
+	if message.Extra == nil {
+		message.Extra = make(map[string]any)
+	}
+	message.Extra[ExtraAnsweredBy] = name
+	if len(attempts) > 0 {
+		message.Extra[ExtraFallbackAttempts] = attempts
+	}
+}
+
+// tokenBudgets counts the tokens used per model in the current window
+type tokenBudgets struct {
+	mu          sync.Mutex
+	window      time.Duration
+	windowStart time.Time
+	used        map[string]int
+}
+
+// exhausted reports whether the model has used its budget in the current window
+func (b *tokenBudgets) exhausted(name string, budget int) bool {
+	if budget <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollLocked()
+	return b.used[name] >= budget
+}
+
+// add counts the tokens reported in the usage of a message
+func (b *tokenBudgets) add(name string, message *schema.Message) {
+	if message == nil || message.ResponseMeta == nil || message.ResponseMeta.Usage == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollLocked()
+	b.used[name] += message.ResponseMeta.Usage.TotalTokens
+}
+
+// rollLocked starts a new window when the current one is over
+func (b *tokenBudgets) rollLocked() {
+	if now := time.Now(); now.Sub(b.windowStart) >= b.window {
+		b.windowStart = now
+		b.used = make(map[string]int)
+	}
+}
@@ -0,0 +1,380 @@
+package chatmodel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// Circuit breaker states reported to the state change hook
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is returned without calling the provider while its circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// retryAfterMessage matches retry hints providers put in error messages, e.g. "Please try again in 20s"
+var retryAfterMessage = regexp.MustCompile(`(?i)(?:retry[- ]after|try again in)[:\s]+(\d+(?:\.\d+)?)\s*(ms|s|sec|seconds?)?`)
+
+// ResilientChatModel bounds every call to a model with a timeout, retries transient errors with
+// exponential backoff and jitter, and fast-fails with ErrCircuitOpen while the provider is down
+//
+// A Retry-After hint of the provider replaces the backoff delay; a hint longer than the maximum
+// delay ends the retries, so a fallback chain on top moves on instead of waiting. The breaker opens
+// after a number of consecutive transient failures and lets a single trial call through once the
+// cooldown is over; errors the provider answered with, like a bad request, count as the provider up.
+type ResilientChatModel struct {
+	inner   model.ToolCallingChatModel
+	name    string
+	timeout time.Duration
+
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+
+	breaker     *circuitBreaker
+	breakerHook func(name, state string)
+}
+
+var _ model.ToolCallingChatModel = (*ResilientChatModel)(nil)
+
+// ResilienceOption configures a ResilientChatModel
+type ResilienceOption func(*ResilientChatModel)
+
+// WithCallTimeout bounds each attempt, zero leaves the deadline to the caller
+func WithCallTimeout(timeout time.Duration) ResilienceOption {
+	return func(r *ResilientChatModel) {
+		r.timeout = timeout
+	}
+}
+
+// WithRetries sets the retries after the first attempt and the backoff delays, which double from
+// baseDelay up to maxDelay
+func WithRetries(maxRetries int, baseDelay, maxDelay time.Duration) ResilienceOption {
+	return func(r *ResilientChatModel) {
+		r.maxRetries = max(maxRetries, 0)
+		if baseDelay > 0 {
+			r.baseDelay = baseDelay
+		}
+		if maxDelay > 0 {
+			r.maxDelay = maxDelay
+		}
+	}
+}
+
+// WithCircuitBreaker opens the breaker after failures consecutive transient errors for cooldown,
+// zero failures disables it
+func WithCircuitBreaker(failures int, cooldown time.Duration) ResilienceOption {
+	return func(r *ResilientChatModel) {
+		if failures <= 0 {
+			r.breaker = nil
+			return
+		}
+		r.breaker = &circuitBreaker{threshold: failures, cooldown: cooldown}
+	}
+}
+
+// WithBreakerHook calls hook with the model name and the new state whenever the breaker changes state
+func WithBreakerHook(hook func(name, state string)) ResilienceOption {
+	return func(r *ResilientChatModel) {
+		r.breakerHook = hook
+	}
+}
+
+// NewResilientChatModel wraps inner, name labels the model in errors and breaker events
+// Without options calls have no timeout, no retries and no breaker
+func NewResilientChatModel(inner model.ToolCallingChatModel, name string, opts ...ResilienceOption) *ResilientChatModel {
+	r := &ResilientChatModel{
+		inner:     inner,
+		name:      name,
+		baseDelay: 500 * time.Millisecond,
+		maxDelay:  10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.breaker != nil {
+		r.breaker.name = name
+		r.breaker.hook = r.breakerHook
+	}
+	return r
+}
+
+// Generate calls the inner model, retrying transient errors
+func (r *ResilientChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	var out *schema.Message
+	err := r.do(ctx, func(ctx context.Context) (func(), error) {
+		callCtx, cancel := r.callContext(ctx)
+		defer cancel()
+		var err error
+		out, err = r.inner.Generate(callCtx, input, opts...)
+		return nil, err
+	})
+	return out, err
+}
+
+// Stream starts a stream of the inner model, retrying transient errors until one starts
+// The call timeout covers the whole stream; errors after it started are left to the reader
+func (r *ResilientChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	var stream *schema.StreamReader[*schema.Message]
+	err := r.do(ctx, func(ctx context.Context) (func(), error) {
+		callCtx, cancel := r.callContext(ctx)
+		var err error
+		stream, err = r.inner.Stream(callCtx, input, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return cancel, nil
+	})
+	return stream, err
+}
+
+// WithTools binds the tools to the inner model, the copy shares the circuit breaker
+func (r *ResilientChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	inner, err := r.inner.WithTools(tools)
+	if err != nil {
+		return nil, err
+	}
+	withTools := *r
+	withTools.inner = inner
+	return &withTools, nil
+}
+
+// callContext returns the context of one attempt
+func (r *ResilientChatModel) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+// do runs call until it succeeds, fails with a permanent error or the retries are used up
+// A successful stream call returns the cancel function of its context, released after the timeout
+func (r *ResilientChatModel) do(ctx context.Context, call func(ctx context.Context) (func(), error)) error {
+	for attempt := 0; ; attempt++ {
+		if !r.breaker.allow() {
+			return fmt.Errorf("%s: %w", r.name, ErrCircuitOpen)
+		}
+		slotCtx, slot := withRetryAfterSlot(ctx)
+		release, err := call(slotCtx)
+		if err == nil {
+			r.breaker.record(false)
+			if release != nil && r.timeout > 0 {
+				time.AfterFunc(r.timeout, release)
+			}
+			return nil
+		}
+
+		// A call the caller gave up on says nothing about the provider
+		if ctx.Err() != nil {
+			r.breaker.release()
+			return err
+		}
+		transient := IsTransient(err)
+		r.breaker.record(transient)
+		if !transient || attempt >= r.maxRetries {
+			if attempt > 0 {
+				return fmt.Errorf("%s failed after %d attempt(s): %w", r.name, attempt+1, err)
+			}
+			return err
+		}
+
+		delay := r.backoff(attempt)
+		if hint, ok := retryAfter(slot, err); ok {
+			if hint > r.maxDelay {
+				return fmt.Errorf("%s asked to retry after %v: %w", r.name, hint, err)
+			}
+			delay = hint
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before retry attempt+1: baseDelay doubled per attempt up to maxDelay,
+// with a random half of it as jitter so clients that failed together do not retry together
+func (r *ResilientChatModel) backoff(attempt int) time.Duration {
+	delay := r.maxDelay
+	if attempt < 30 {
+		delay = min(r.baseDelay<<attempt, r.maxDelay)
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// retryAfter returns the Retry-After header of the failed response, or a hint in the error message
+func retryAfter(slot *retryAfterSlot, err error) (time.Duration, bool) {
+	if delay, ok := slot.get(); ok {
+		return delay, true
+	}
+	match := retryAfterMessage.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0, false
+	}
+	value, parseErr := strconv.ParseFloat(match[1], 64)
+	if parseErr != nil {
+		return 0, false
+	}
+	if strings.EqualFold(match[2], "ms") {
+		return time.Duration(value * float64(time.Millisecond)), true
+	}
+	return time.Duration(value * float64(time.Second)), true
+}
+
+// circuitBreaker counts consecutive transient failures of a provider, a nil breaker is always closed
+type circuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	hook      func(name, state string)
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool // a half-open trial call is in flight
+}
+
+// allow reports whether a call may go out, letting one trial call through after the cooldown
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	b.notify(BreakerHalfOpen)
+	return true
+}
+
+// record counts the result of a call, failed is true for transient errors
+func (b *circuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.failures >= b.threshold
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		if wasOpen {
+			b.notify(BreakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		b.notify(BreakerOpen)
+	}
+}
+
+// release ends a call without counting its result, letting the next trial call through
+func (b *circuitBreaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// notify reports a state change to the hook
+func (b *circuitBreaker) notify(state string) {
+	if b.hook != nil {
+		b.hook(b.name, state)
+	}
+}
+
+// retryAfterKey is the context key of the slot the transport stores Retry-After headers in
+type retryAfterKey struct{}
+
+// retryAfterSlot receives the Retry-After header of a failed response of one attempt
+type retryAfterSlot struct {
+	mu    sync.Mutex
+	delay time.Duration
+	set   bool
+}
+
+// withRetryAfterSlot returns a context the transport reports Retry-After headers of its requests to
+func withRetryAfterSlot(ctx context.Context) (context.Context, *retryAfterSlot) {
+	slot := &retryAfterSlot{}
+	return context.WithValue(ctx, retryAfterKey{}, slot), slot
+}
+
+// get returns the recorded delay
+func (s *retryAfterSlot) get() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delay, s.set
+}
+
+// retryAfterTransport records the Retry-After header of 429 and 503 responses in the slot of the
+// request context, the provider SDKs drop response headers from their errors
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip sends the request with the base transport
+func (t retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return resp, err
+	}
+	slot, ok := req.Context().Value(retryAfterKey{}).(*retryAfterSlot)
+	if !ok {
+		return resp, err
+	}
+	if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+		slot.mu.Lock()
+		slot.delay, slot.set = delay, true
+		slot.mu.Unlock()
+	}
+	return resp, err
+}
+
+// parseRetryAfter parses a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// newHTTPClient returns the HTTP client of the provider SDKs, reporting Retry-After headers
+// to ResilientChatModel
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: retryAfterTransport{base: http.DefaultTransport}}
+}
@@ -0,0 +1,64 @@
+package chatmodel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// RouterChatModel sends each call to the model chosen with the model call option
+//
+// Every routed model keeps its own retries, circuit breaker and token budgets, so calls to one
+// model never count against another. Calls without a model option, or with a model that has no
+// route, go to the default model.
+type RouterChatModel struct {
+	defaultModel model.ToolCallingChatModel
+	routes       map[string]model.ToolCallingChatModel
+}
+
+var _ model.ToolCallingChatModel = (*RouterChatModel)(nil)
+
+// NewRouterChatModel creates a router over routes keyed by model name
+func NewRouterChatModel(defaultModel model.ToolCallingChatModel, routes map[string]model.ToolCallingChatModel) *RouterChatModel {
+	return &RouterChatModel{defaultModel: defaultModel, routes: routes}
+}
+
+// Generate calls the model chosen by the call options
+func (r *RouterChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	return r.route(opts).Generate(ctx, input, opts...)
+}
+
+// Stream starts a stream of the model chosen by the call options
+func (r *RouterChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	return r.route(opts).Stream(ctx, input, opts...)
+}
+
+// WithTools binds the tools to the default model and every route
+func (r *RouterChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	defaultModel, err := r.defaultModel.WithTools(tools)
+	if err != nil {
+		return nil, err
+	}
+	routes := make(map[string]model.ToolCallingChatModel, len(r.routes))
+	for name, routed := range r.routes {
+		withTools, err := routed.WithTools(tools)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		routes[name] = withTools
+	}
+	return &RouterChatModel{defaultModel: defaultModel, routes: routes}, nil
+}
+
+// route returns the model named in the call options, or the default model
+func (r *RouterChatModel) route(opts []model.Option) model.ToolCallingChatModel {
+	common := model.GetCommonOptions(&model.Options{}, opts...)
+	if common.Model != nil {
+		if routed, ok := r.routes[*common.Model]; ok {
+			return routed
+		}
+	}
+	return r.defaultModel
+}
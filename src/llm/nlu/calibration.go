@@ -0,0 +1,350 @@
+package nlu
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CalibrationMethod selects how raw confidences are mapped to calibrated probabilities
+type CalibrationMethod string
+
+const (
+	// CalibrationPlatt fits a sigmoid 1/(1+exp(A*x+B)) to the raw confidence
+	CalibrationPlatt CalibrationMethod = "platt"
+	// CalibrationIsotonic fits a monotone step function with pool-adjacent-violators
+	CalibrationIsotonic CalibrationMethod = "isotonic"
+)
+
+// Calibration kinds matching the tuple types with model-reported confidences
+const (
+	CalibrationKindIntent = "intent"
+	CalibrationKindEntity = "entity"
+)
+
+// CalibrationAnyLabel is the fallback calibrator fitted on all labels of a kind
+const CalibrationAnyLabel = "*"
+
+// DefaultCalibrationMinSamples is the minimum number of examples needed to fit a per-label calibrator
+const DefaultCalibrationMinSamples = 20
+
+// LabeledExample is one line of the calibration dataset
+// Correct tells whether the predicted label was right for the analysed message
+type LabeledExample struct {
+	Model      string  `json:"model"`
+	Kind       string  `json:"kind"` // intent, entity
+	Label      string  `json:"label"`
+	Confidence float64 `json:"confidence"`
+	Correct    bool    `json:"correct"`
+}
+
+// Calibrator maps a raw confidence to a calibrated probability
+type Calibrator struct {
+	Method  CalibrationMethod `json:"method"`
+	Samples int               `json:"samples"`
+
+	// Platt scaling parameters
+	A float64 `json:"a,omitempty"`
+	B float64 `json:"b,omitempty"`
+
+	// Isotonic regression steps: Values[i] applies from Thresholds[i] on
+	Thresholds []float64 `json:"thresholds,omitempty"`
+	Values     []float64 `json:"values,omitempty"`
+}
+
+// ModelCalibration holds the per-label calibrators of a single model
+type ModelCalibration struct {
+	Intents  map[string]*Calibrator `json:"intents"`
+	Entities map[string]*Calibrator `json:"entities"`
+}
+
+// Calibrations holds the fitted calibrators of every model, as stored in the calibration file
+type Calibrations struct {
+	Method CalibrationMethod            `json:"method"`
+	Models map[string]*ModelCalibration `json:"models"`
+}
+
+// Apply returns the calibrated confidence for a raw confidence
+func (c *Calibrator) Apply(raw float64) float64 {
+	switch c.Method {
+	case CalibrationPlatt:
+		return clampUnit(1 / (1 + math.Exp(c.A*raw+c.B)))
+	case CalibrationIsotonic:
+		if len(c.Values) == 0 {
+			return raw
+		}
+		idx := sort.SearchFloat64s(c.Thresholds, raw)
+		if idx == len(c.Thresholds) || c.Thresholds[idx] > raw {
+			idx--
+		}
+		if idx < 0 {
+			idx = 0
+		}
+		return c.Values[idx]
+	default:
+		return raw
+	}
+}
+
+// ReadLabeledExamples reads a JSONL calibration dataset, skipping blank lines
+func ReadLabeledExamples(r io.Reader) ([]LabeledExample, error) {
+	var examples []LabeledExample
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var example LabeledExample
+		if err := json.Unmarshal([]byte(text), &example); err != nil {
+			return nil, fmt.Errorf("invalid calibration example on line %d: %v", line, err)
+		}
+		if example.Kind != CalibrationKindIntent && example.Kind != CalibrationKindEntity {
+			return nil, fmt.Errorf("invalid calibration kind on line %d: %q", line, example.Kind)
+		}
+		examples = append(examples, example)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read calibration examples: %v", err)
+	}
+	return examples, nil
+}
+
+// FitCalibrations fits per-model, per-label calibrators from labeled examples
+// Labels with fewer than minSamples examples are covered by the CalibrationAnyLabel calibrator of their kind
+func FitCalibrations(examples []LabeledExample, method CalibrationMethod, minSamples int) (*Calibrations, error) {
+	if method != CalibrationPlatt && method != CalibrationIsotonic {
+		return nil, fmt.Errorf("unknown calibration method: %s", method)
+	}
+	if minSamples <= 0 {
+		minSamples = DefaultCalibrationMinSamples
+	}
+
+	// model -> kind -> label -> examples
+	grouped := make(map[string]map[string]map[string][]LabeledExample)
+	for _, example := range examples {
+		if grouped[example.Model] == nil {
+			grouped[example.Model] = make(map[string]map[string][]LabeledExample)
+		}
+		if grouped[example.Model][example.Kind] == nil {
+			grouped[example.Model][example.Kind] = make(map[string][]LabeledExample)
+		}
+		byLabel := grouped[example.Model][example.Kind]
+		byLabel[example.Label] = append(byLabel[example.Label], example)
+		byLabel[CalibrationAnyLabel] = append(byLabel[CalibrationAnyLabel], example)
+	}
+
+	calibrations := &Calibrations{Method: method, Models: make(map[string]*ModelCalibration)}
+	for modelName, kinds := range grouped {
+		modelCalibration := &ModelCalibration{
+			Intents:  make(map[string]*Calibrator),
+			Entities: make(map[string]*Calibrator),
+		}
+		for kind, byLabel := range kinds {
+			target := modelCalibration.Intents
+			if kind == CalibrationKindEntity {
+				target = modelCalibration.Entities
+			}
+			for label, labelExamples := range byLabel {
+				if len(labelExamples) < minSamples {
+					continue
+				}
+				target[label] = fitCalibrator(labelExamples, method)
+			}
+		}
+		calibrations.Models[modelName] = modelCalibration
+	}
+	return calibrations, nil
+}
+
+// fitCalibrator fits a single calibrator on the examples of one label
+func fitCalibrator(examples []LabeledExample, method CalibrationMethod) *Calibrator {
+	if method == CalibrationIsotonic {
+		return fitIsotonic(examples)
+	}
+	return fitPlatt(examples)
+}
+
+// fitPlatt fits Platt scaling with Newton's method on smoothed targets (Platt, 1999)
+func fitPlatt(examples []LabeledExample) *Calibrator {
+	positives, negatives := 0.0, 0.0
+	for _, example := range examples {
+		if example.Correct {
+			positives++
+		} else {
+			negatives++
+		}
+	}
+	highTarget := (positives + 1) / (positives + 2)
+	lowTarget := 1 / (negatives + 2)
+
+	a, b := 0.0, math.Log((negatives+1)/(positives+1))
+	for iteration := 0; iteration < 100; iteration++ {
+		// Gradient and Hessian of the log loss with respect to (a, b)
+		var gradA, gradB, hAA, hAB, hBB float64
+		for _, example := range examples {
+			target := lowTarget
+			if example.Correct {
+				target = highTarget
+			}
+			p := 1 / (1 + math.Exp(a*example.Confidence+b))
+			d := target - p
+			w := p * (1 - p)
+			gradA += example.Confidence * d
+			gradB += d
+			hAA += example.Confidence * example.Confidence * w
+			hAB += example.Confidence * w
+			hBB += w
+		}
+		// Small ridge keeps the Hessian invertible when all confidences are equal
+		hAA += 1e-12
+		hBB += 1e-12
+		det := hAA*hBB - hAB*hAB
+		if det == 0 {
+			break
+		}
+		stepA := (hBB*gradA - hAB*gradB) / det
+		stepB := (hAA*gradB - hAB*gradA) / det
+		a -= stepA
+		b -= stepB
+		if math.Abs(stepA) < 1e-9 && math.Abs(stepB) < 1e-9 {
+			break
+		}
+	}
+
+	return &Calibrator{Method: CalibrationPlatt, Samples: len(examples), A: a, B: b}
+}
+
+// fitIsotonic fits a non-decreasing step function with pool-adjacent-violators
+func fitIsotonic(examples []LabeledExample) *Calibrator {
+	sorted := make([]LabeledExample, len(examples))
+	copy(sorted, examples)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Confidence < sorted[j].Confidence })
+
+	type block struct {
+		start  float64
+		sum    float64
+		weight float64
+	}
+	var blocks []block
+	for _, example := range sorted {
+		value := 0.0
+		if example.Correct {
+			value = 1
+		}
+		if n := len(blocks); n > 0 && blocks[n-1].start == example.Confidence {
+			blocks[n-1].sum += value
+			blocks[n-1].weight++
+		} else {
+			blocks = append(blocks, block{start: example.Confidence, sum: value, weight: 1})
+		}
+		// Merge backwards while the sequence of means decreases
+		for n := len(blocks); n > 1 && blocks[n-2].sum/blocks[n-2].weight > blocks[n-1].sum/blocks[n-1].weight; n = len(blocks) {
+			blocks[n-2].sum += blocks[n-1].sum
+			blocks[n-2].weight += blocks[n-1].weight
+			blocks = blocks[:n-1]
+		}
+	}
+
+	calibrator := &Calibrator{Method: CalibrationIsotonic, Samples: len(examples)}
+	for _, b := range blocks {
+		calibrator.Thresholds = append(calibrator.Thresholds, b.start)
+		calibrator.Values = append(calibrator.Values, b.sum/b.weight)
+	}
+	return calibrator
+}
+
+// LoadCalibrations reads a calibration file written by SaveCalibrations
+func LoadCalibrations(path string) (*Calibrations, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read calibration file: %v", err)
+	}
+	var calibrations Calibrations
+	if err := json.Unmarshal(data, &calibrations); err != nil {
+		return nil, fmt.Errorf("failed to parse calibration file: %v", err)
+	}
+	return &calibrations, nil
+}
+
+// SaveCalibrations writes the calibrators to a JSON file
+func SaveCalibrations(path string, calibrations *Calibrations) error {
+	data, err := json.MarshalIndent(calibrations, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode calibrations: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write calibration file: %v", err)
+	}
+	return nil
+}
+
+// ForModel returns the calibrators fitted for the model, nil when there are none
+func (c *Calibrations) ForModel(modelName string) *ModelCalibration {
+	if c == nil {
+		return nil
+	}
+	return c.Models[modelName]
+}
+
+// calibrator returns the label calibrator, falling back to the kind-wide one
+func (m *ModelCalibration) calibrator(kind, label string) *Calibrator {
+	calibrators := m.Intents
+	if kind == CalibrationKindEntity {
+		calibrators = m.Entities
+	}
+	if calibrator, ok := calibrators[label]; ok {
+		return calibrator
+	}
+	return calibrators[CalibrationAnyLabel]
+}
+
+// applyCalibration replaces intent and entity confidences with calibrated values
+// The model-reported value is kept in Metadata["raw_confidence"]
+func (s *parseSession) applyCalibration() {
+	calibration := s.processor.calibration
+	if calibration == nil {
+		return
+	}
+
+	calibrated := 0
+	for i := range s.response.Intents {
+		intent := &s.response.Intents[i]
+		if calibrateConfidence(calibration.calibrator(CalibrationKindIntent, intent.Name), &intent.Confidence, &intent.Metadata) {
+			calibrated++
+		}
+	}
+	for i := range s.response.Entities {
+		entity := &s.response.Entities[i]
+		if calibrateConfidence(calibration.calibrator(CalibrationKindEntity, entity.Type), &entity.Confidence, &entity.Metadata) {
+			calibrated++
+		}
+	}
+	s.response.ParsingMetadata["calibrated_count"] = calibrated
+}
+
+// calibrateConfidence applies the calibrator in place, returns false when there is none
+func calibrateConfidence(calibrator *Calibrator, confidence *float64, metadata *map[string]any) bool {
+	if calibrator == nil {
+		return false
+	}
+	if *metadata == nil {
+		*metadata = make(map[string]any)
+	}
+	(*metadata)["raw_confidence"] = *confidence
+	*confidence = math.Round(calibrator.Apply(*confidence)*10000) / 10000
+	return true
+}
+
+// clampUnit limits a probability to [0, 1]
+func clampUnit(value float64) float64 {
+	return math.Max(0, math.Min(1, value))
+}
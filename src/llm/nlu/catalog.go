@@ -0,0 +1,350 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/model"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Catalog sources matching the prompt's "extracted_from" metadata
+const (
+	CatalogSourceDefault    = "default"
+	CatalogSourceAdditional = "additional"
+
+	// DefaultCatalogMatchThreshold is the minimum similarity for remapping an unknown label
+	DefaultCatalogMatchThreshold = 0.75
+	// containmentSimilarity scores labels where one is a token-aligned part of the other
+	containmentSimilarity = 0.8
+	// minStemLength is the shortest label considered a stem of a longer one
+	minStemLength = 4
+)
+
+// CatalogIntent is an allowed intent with its configured priority
+// Category, description, synonyms and examples are only set by catalog files
+type CatalogIntent struct {
+	Name        string
+	Priority    float64
+	Source      string
+	Category    string
+	Description string
+	Synonyms    []string
+	Examples    CatalogExamples
+}
+
+// CatalogEntity is an allowed entity type
+type CatalogEntity struct {
+	Name        string
+	Source      string
+	Category    string
+	Description string
+	Synonyms    []string
+	Examples    CatalogExamples
+}
+
+// CatalogExamples are example utterances of an intent or example values of an entity
+type CatalogExamples struct {
+	Thai    []string `yaml:"th,omitempty"`
+	English []string `yaml:"en,omitempty"`
+}
+
+// CatalogCorrection records a label remapped or re-prioritized during validation
+type CatalogCorrection struct {
+	Kind       string  `json:"kind"` // intent, entity, intent_priority
+	Original   string  `json:"original"`
+	Corrected  string  `json:"corrected"`
+	Similarity float64 `json:"similarity,omitempty"`
+}
+
+// Catalog holds the intent and entity labels the model is allowed to produce
+type Catalog struct {
+	Intents        []CatalogIntent
+	Entities       []CatalogEntity
+	MatchThreshold float64
+
+	intentIndex map[string]int
+	entityIndex map[string]int
+}
+
+// NewCatalog creates a catalog from explicit intent and entity definitions
+func NewCatalog(intents []CatalogIntent, entities []CatalogEntity) *Catalog {
+	c := &Catalog{
+		Intents:        intents,
+		Entities:       entities,
+		MatchThreshold: DefaultCatalogMatchThreshold,
+		intentIndex:    make(map[string]int, len(intents)),
+		entityIndex:    make(map[string]int, len(entities)),
+	}
+	for i, intent := range intents {
+		for _, synonym := range intent.Synonyms {
+			c.intentIndex[normalizeLabel(synonym)] = i
+		}
+	}
+	for i, entity := range entities {
+		for _, synonym := range entity.Synonyms {
+			c.entityIndex[normalizeLabel(synonym)] = i
+		}
+	}
+	// Names win over synonyms of other labels
+	for i, intent := range intents {
+		c.intentIndex[normalizeLabel(intent.Name)] = i
+	}
+	for i, entity := range entities {
+		c.entityIndex[normalizeLabel(entity.Name)] = i
+	}
+	return c
+}
+
+// NewCatalogFromConfig builds the catalog from NLU_CATALOG_FILE when set, otherwise from the
+// comma-separated NLUConfig lists where intents use "name:priority" entries and entities are plain names
+func NewCatalogFromConfig(nluConfig *model.NLUConfig) (*Catalog, error) {
+	if nluConfig.CatalogFile != "" {
+		return LoadCatalogFile(nluConfig.CatalogFile)
+	}
+
+	var intents []CatalogIntent
+	for _, list := range []struct {
+		value  string
+		source string
+	}{
+		{nluConfig.DefaultIntent, CatalogSourceDefault},
+		{nluConfig.AdditionalIntent, CatalogSourceAdditional},
+	} {
+		parsed, err := parseIntentList(list.value, list.source)
+		if err != nil {
+			return nil, err
+		}
+		intents = append(intents, parsed...)
+	}
+
+	var entities []CatalogEntity
+	for _, list := range []struct {
+		value  string
+		source string
+	}{
+		{nluConfig.DefaultEntity, CatalogSourceDefault},
+		{nluConfig.AdditionalEntity, CatalogSourceAdditional},
+	} {
+		for _, name := range splitList(list.value) {
+			// Tolerate "name:score" entries for entities as well
+			name, _, _ = strings.Cut(name, ":")
+			entities = append(entities, CatalogEntity{Name: strings.TrimSpace(name), Source: list.source})
+		}
+	}
+
+	return NewCatalog(intents, entities), nil
+}
+
+// parseIntentList parses "greet:0.1, purchase_intent:0.8" into catalog intents
+func parseIntentList(list string, source string) ([]CatalogIntent, error) {
+	var intents []CatalogIntent
+	for _, item := range splitList(list) {
+		name, score, found := strings.Cut(item, ":")
+		intent := CatalogIntent{Name: strings.TrimSpace(name), Source: source}
+		if found {
+			priority, err := parseFloat(score, "priority for intent "+intent.Name)
+			if err != nil {
+				return nil, err
+			}
+			intent.Priority = priority
+		}
+		if intent.Name == "" {
+			return nil, fmt.Errorf("empty intent name in %s intent list", source)
+		}
+		intents = append(intents, intent)
+	}
+	return intents, nil
+}
+
+// splitList splits a comma-separated config value, dropping blank items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// MatchIntent returns the catalog intent for name, falling back to the most similar allowed intent
+// The similarity is 1 for exact matches; ok is false when nothing reaches the match threshold
+func (c *Catalog) MatchIntent(name string) (intent CatalogIntent, similarity float64, ok bool) {
+	names := make([]string, len(c.Intents))
+	for i, intent := range c.Intents {
+		names[i] = intent.Name
+	}
+	idx, similarity := c.match(name, c.intentIndex, names)
+	if idx < 0 {
+		return CatalogIntent{}, similarity, false
+	}
+	return c.Intents[idx], similarity, true
+}
+
+// MatchEntity returns the catalog entity for name, falling back to the most similar allowed type
+func (c *Catalog) MatchEntity(name string) (entity CatalogEntity, similarity float64, ok bool) {
+	names := make([]string, len(c.Entities))
+	for i, entity := range c.Entities {
+		names[i] = entity.Name
+	}
+	idx, similarity := c.match(name, c.entityIndex, names)
+	if idx < 0 {
+		return CatalogEntity{}, similarity, false
+	}
+	return c.Entities[idx], similarity, true
+}
+
+// intentNames returns the allowed intent names, typed for schema enums
+func (c *Catalog) intentNames() []any {
+	names := make([]any, len(c.Intents))
+	for i, intent := range c.Intents {
+		names[i] = intent.Name
+	}
+	return names
+}
+
+// entityNames returns the allowed entity types, typed for schema enums
+func (c *Catalog) entityNames() []any {
+	names := make([]any, len(c.Entities))
+	for i, entity := range c.Entities {
+		names[i] = entity.Name
+	}
+	return names
+}
+
+// match finds the index of the allowed label closest to name, -1 when below the threshold
+func (c *Catalog) match(name string, index map[string]int, names []string) (int, float64) {
+	normalized := normalizeLabel(name)
+	if idx, ok := index[normalized]; ok {
+		return idx, 1.0
+	}
+
+	bestIdx, bestSimilarity := -1, 0.0
+	for i, candidate := range names {
+		similarity := labelSimilarity(normalized, normalizeLabel(candidate))
+		if similarity > bestSimilarity {
+			bestIdx, bestSimilarity = i, similarity
+		}
+	}
+	if bestSimilarity < c.MatchThreshold {
+		return -1, bestSimilarity
+	}
+	return bestIdx, bestSimilarity
+}
+
+// normalizeLabel lowercases a label and converts separators to snake_case
+func normalizeLabel(label string) string {
+	label = strings.ToLower(strings.TrimSpace(label))
+	return strings.NewReplacer(" ", "_", "-", "_", ".", "_").Replace(label)
+}
+
+// labelSimilarity scores two normalized labels in [0, 1]
+// Uses edit distance, with a fixed score when one label is a token-aligned part
+// or a word-stem prefix ("greeting" -> "greet") of the other
+func labelSimilarity(a, b string) float64 {
+	similarity := 1 - float64(levenshtein(a, b))/float64(max(len([]rune(a)), len([]rune(b)), 1))
+	if a == "" || b == "" {
+		return similarity
+	}
+
+	contained := strings.Contains("_"+a+"_", "_"+b+"_") || strings.Contains("_"+b+"_", "_"+a+"_")
+	shorter, longer := a, b
+	if len(shorter) > len(longer) {
+		shorter, longer = longer, shorter
+	}
+	stemPrefix := len([]rune(shorter)) >= minStemLength && !strings.Contains(longer, "_") && strings.HasPrefix(longer, shorter)
+	if contained || stemPrefix {
+		similarity = max(similarity, containmentSimilarity)
+	}
+	return similarity
+}
+
+// levenshtein computes the rune-level edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// applyCatalog validates an intent or entity record against the processor catalog
+// Remapped labels are flagged in the record metadata and collected as session corrections
+func (s *parseSession) applyCatalog(parser TupleParser) error {
+	catalog := s.processor.catalog
+	if catalog == nil {
+		return nil
+	}
+
+	switch p := parser.(type) {
+	case *IntentParser:
+		allowed, similarity, ok := catalog.MatchIntent(p.Intent.Name)
+		if !ok {
+			return fmt.Errorf("intent %q not in catalog (best similarity %.2f)", p.Intent.Name, similarity)
+		}
+		if allowed.Name != p.Intent.Name {
+			s.correct(p.Intent.Metadata, CatalogCorrection{Kind: "intent", Original: p.Intent.Name, Corrected: allowed.Name, Similarity: similarity})
+			p.Intent.Name = allowed.Name
+		}
+		// The configured priority is authoritative, the model only echoes it
+		if allowed.Priority != p.Intent.Priority {
+			s.correct(p.Intent.Metadata, CatalogCorrection{
+				Kind:      "intent_priority",
+				Original:  strconv.FormatFloat(p.Intent.Priority, 'f', -1, 64),
+				Corrected: strconv.FormatFloat(allowed.Priority, 'f', -1, 64),
+			})
+			p.Intent.Priority = allowed.Priority
+		}
+		setCategory(p.Intent.Metadata, allowed.Category)
+	case *EntityParser:
+		allowed, similarity, ok := catalog.MatchEntity(p.Entity.Type)
+		if !ok {
+			return fmt.Errorf("entity type %q not in catalog (best similarity %.2f)", p.Entity.Type, similarity)
+		}
+		if allowed.Name != p.Entity.Type {
+			s.correct(p.Entity.Metadata, CatalogCorrection{Kind: "entity", Original: p.Entity.Type, Corrected: allowed.Name, Similarity: similarity})
+			p.Entity.Type = allowed.Name
+		}
+		setCategory(p.Entity.Metadata, allowed.Category)
+	case *AspectSentimentParser:
+		allowed, similarity, ok := catalog.MatchEntity(p.AspectSentiment.Aspect)
+		if !ok {
+			return fmt.Errorf("aspect %q not in catalog (best similarity %.2f)", p.AspectSentiment.Aspect, similarity)
+		}
+		if allowed.Name != p.AspectSentiment.Aspect {
+			s.correct(p.AspectSentiment.Metadata, CatalogCorrection{Kind: "aspect", Original: p.AspectSentiment.Aspect, Corrected: allowed.Name, Similarity: similarity})
+			p.AspectSentiment.Aspect = allowed.Name
+		}
+	}
+	return nil
+}
+
+// correct records a catalog correction on the session and in the record metadata
+func (s *parseSession) correct(metadata map[string]any, correction CatalogCorrection) {
+	s.corrections = append(s.corrections, correction)
+	if metadata == nil {
+		return
+	}
+	corrections, _ := metadata["catalog_corrections"].([]CatalogCorrection)
+	metadata["catalog_corrections"] = append(corrections, correction)
+}
+
+// setCategory records the parent category of a catalog label in the record metadata
+func setCategory(metadata map[string]any, category string) {
+	if category != "" && metadata != nil {
+		metadata["category"] = category
+	}
+}
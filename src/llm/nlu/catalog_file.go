@@ -0,0 +1,293 @@
+package nlu
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// catalogFile is the YAML layout of NLU_CATALOG_FILE
+//
+//	intents:
+//	  - name: purchase_intent
+//	    priority: 0.8
+//	    source: default            # default or additional
+//	    category: commerce
+//	    description: The user wants to buy a product
+//	    synonyms: [buy, order_product]
+//	    examples:
+//	      th: [อยากซื้อรองเท้า]
+//	      en: [I want to buy shoes]
+//	entities:
+//	  - name: product
+//	    source: default
+//	    category: item
+//	    description: A product or product type mentioned by the user
+//	    examples:
+//	      th: [รองเท้า]
+//	      en: [shoes]
+type catalogFile struct {
+	Intents  []catalogFileEntry `yaml:"intents"`
+	Entities []catalogFileEntry `yaml:"entities"`
+}
+
+// catalogFileEntry is an intent or entity of a catalog file, entities have no priority
+type catalogFileEntry struct {
+	Name        string          `yaml:"name"`
+	Priority    float64         `yaml:"priority,omitempty"`
+	Source      string          `yaml:"source,omitempty"`
+	Category    string          `yaml:"category,omitempty"`
+	Description string          `yaml:"description,omitempty"`
+	Synonyms    []string        `yaml:"synonyms,omitempty"`
+	Examples    CatalogExamples `yaml:"examples,omitempty"`
+}
+
+// LoadCatalogFile reads an intent/entity catalog from a YAML file
+func LoadCatalogFile(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog file: %v", err)
+	}
+	catalog, err := ParseCatalogYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("catalog file %s: %v", path, err)
+	}
+	return catalog, nil
+}
+
+// ParseCatalogYAML parses and validates a YAML catalog
+// Names must be unique, intent priorities within [0, 1] and sources default or additional;
+// at least one intent and one entity must come from the default source, as the prompts require
+func ParseCatalogYAML(data []byte) (*Catalog, error) {
+	var file catalogFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog file: %v", err)
+	}
+	if len(file.Intents) == 0 {
+		return nil, fmt.Errorf("catalog file defines no intents")
+	}
+
+	intents := make([]CatalogIntent, 0, len(file.Intents))
+	seen := make(map[string]bool)
+	for _, entry := range file.Intents {
+		if err := validateCatalogEntry(&entry, "intent", seen); err != nil {
+			return nil, err
+		}
+		if entry.Priority < 0 || entry.Priority > 1 {
+			return nil, fmt.Errorf("intent %s priority must be within [0, 1], got %v", entry.Name, entry.Priority)
+		}
+		intents = append(intents, CatalogIntent{
+			Name:        entry.Name,
+			Priority:    entry.Priority,
+			Source:      entry.Source,
+			Category:    entry.Category,
+			Description: entry.Description,
+			Synonyms:    entry.Synonyms,
+			Examples:    entry.Examples,
+		})
+	}
+
+	entities := make([]CatalogEntity, 0, len(file.Entities))
+	seen = make(map[string]bool)
+	for _, entry := range file.Entities {
+		if err := validateCatalogEntry(&entry, "entity", seen); err != nil {
+			return nil, err
+		}
+		entities = append(entities, CatalogEntity{
+			Name:        entry.Name,
+			Source:      entry.Source,
+			Category:    entry.Category,
+			Description: entry.Description,
+			Synonyms:    entry.Synonyms,
+			Examples:    entry.Examples,
+		})
+	}
+
+	catalog := NewCatalog(intents, entities)
+	if err := catalog.checkPromptVariables(); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// checkPromptVariables rejects catalogs leaving a required prompt variable empty,
+// which would otherwise only fail when the prompt is rendered
+func (c *Catalog) checkPromptVariables() error {
+	values := PromptVariablesFromCatalog(c, nil).values()
+	for _, check := range []struct{ variable, kind string }{{"DefaultIntent", "intent"}, {"DefaultEntity", "entity"}} {
+		if values[check.variable] == "" {
+			return fmt.Errorf("catalog has no %s with source default, required by the prompt variable %s", check.kind, check.variable)
+		}
+	}
+	return nil
+}
+
+// validateCatalogEntry checks the name and source of an entry, defaulting the source
+func validateCatalogEntry(entry *catalogFileEntry, kind string, seen map[string]bool) error {
+	entry.Name = strings.TrimSpace(entry.Name)
+	if entry.Name == "" {
+		return fmt.Errorf("catalog file has an %s without a name", kind)
+	}
+	if strings.ContainsAny(entry.Name, ",:") {
+		return fmt.Errorf("%s name %q cannot contain ',' or ':'", kind, entry.Name)
+	}
+	for _, name := range append([]string{entry.Name}, entry.Synonyms...) {
+		key := normalizeLabel(name)
+		if seen[key] {
+			return fmt.Errorf("%s name or synonym %q is defined twice", kind, name)
+		}
+		seen[key] = true
+	}
+
+	switch entry.Source {
+	case "":
+		entry.Source = CatalogSourceDefault
+	case CatalogSourceDefault, CatalogSourceAdditional:
+	default:
+		return fmt.Errorf("%s %s has unknown source %q, expected default or additional", kind, entry.Name, entry.Source)
+	}
+	return nil
+}
+
+// YAML encodes the catalog in the catalog file format
+func (c *Catalog) YAML() ([]byte, error) {
+	var file catalogFile
+	for _, intent := range c.Intents {
+		file.Intents = append(file.Intents, catalogFileEntry{
+			Name:        intent.Name,
+			Priority:    intent.Priority,
+			Source:      intent.Source,
+			Category:    intent.Category,
+			Description: intent.Description,
+			Synonyms:    intent.Synonyms,
+			Examples:    intent.Examples,
+		})
+	}
+	for _, entity := range c.Entities {
+		file.Entities = append(file.Entities, catalogFileEntry{
+			Name:        entity.Name,
+			Source:      entity.Source,
+			Category:    entity.Category,
+			Description: entity.Description,
+			Synonyms:    entity.Synonyms,
+			Examples:    entity.Examples,
+		})
+	}
+	data, err := yaml.Marshal(&file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode catalog: %v", err)
+	}
+	return data, nil
+}
+
+// IntentList returns the "name:priority" list of the intents from source, as in NLU_DEFAULT_INTENT
+func (c *Catalog) IntentList(source string) string {
+	var items []string
+	for _, intent := range c.Intents {
+		if intent.Source == source {
+			items = append(items, intent.Name+":"+strconv.FormatFloat(intent.Priority, 'f', -1, 64))
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+// EntityList returns the comma-separated entity types from source, as in NLU_DEFAULT_ENTITY
+func (c *Catalog) EntityList(source string) string {
+	var items []string
+	for _, entity := range c.Entities {
+		if entity.Source == source {
+			items = append(items, entity.Name)
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+// promptDetails renders the descriptions and examples of the catalog for the system prompt
+// Empty when no label has a description or examples, as for catalogs built from the env lists
+func (c *Catalog) promptDetails() string {
+	var b strings.Builder
+	writeLabel := func(name, category, description string, examples CatalogExamples) {
+		if description == "" && len(examples.Thai) == 0 && len(examples.English) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n- %s", name)
+		if category != "" {
+			fmt.Fprintf(&b, " (%s)", category)
+		}
+		if description != "" {
+			fmt.Fprintf(&b, ": %s", description)
+		}
+		if all := append(append([]string{}, examples.Thai...), examples.English...); len(all) > 0 {
+			fmt.Fprintf(&b, " e.g. %s", quoteAll(all))
+		}
+	}
+
+	for _, intent := range c.Intents {
+		writeLabel(intent.Name, intent.Category, intent.Description, intent.Examples)
+	}
+	intents := b.String()
+	b.Reset()
+	for _, entity := range c.Entities {
+		writeLabel(entity.Name, entity.Category, entity.Description, entity.Examples)
+	}
+	entities := b.String()
+
+	if intents == "" && entities == "" {
+		return ""
+	}
+	b.Reset()
+	b.WriteString("<catalog_details>")
+	if intents != "" {
+		b.WriteString("\n**Intents:**" + intents)
+	}
+	if entities != "" {
+		b.WriteString("\n**Entities:**" + entities)
+	}
+	b.WriteString("\n</catalog_details>")
+	return b.String()
+}
+
+// Markdown documents the catalog as tables of intents and entities
+func (c *Catalog) Markdown() string {
+	var b strings.Builder
+	b.WriteString("# NLU catalog\n\n")
+	b.WriteString("Generated from the NLU catalog, do not edit by hand.\n\n")
+
+	b.WriteString("## Intents\n\n")
+	b.WriteString("| Intent | Priority | Source | Category | Description | Synonyms | Examples (th) | Examples (en) |\n")
+	b.WriteString("|---|---|---|---|---|---|---|---|\n")
+	for _, intent := range c.Intents {
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s | %s | %s | %s |\n",
+			intent.Name, strconv.FormatFloat(intent.Priority, 'f', -1, 64), intent.Source, intent.Category,
+			markdownCell(intent.Description), markdownCell(strings.Join(intent.Synonyms, ", ")),
+			markdownCell(quoteAll(intent.Examples.Thai)), markdownCell(quoteAll(intent.Examples.English)))
+	}
+
+	b.WriteString("\n## Entities\n\n")
+	b.WriteString("| Entity | Source | Category | Description | Synonyms | Examples (th) | Examples (en) |\n")
+	b.WriteString("|---|---|---|---|---|---|---|\n")
+	for _, entity := range c.Entities {
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s | %s | %s |\n",
+			entity.Name, entity.Source, entity.Category,
+			markdownCell(entity.Description), markdownCell(strings.Join(entity.Synonyms, ", ")),
+			markdownCell(quoteAll(entity.Examples.Thai)), markdownCell(quoteAll(entity.Examples.English)))
+	}
+	return b.String()
+}
+
+// quoteAll joins values as a comma-separated list of quoted strings
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// markdownCell escapes a value for a markdown table cell
+func markdownCell(value string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(value)
+}
@@ -0,0 +1,30 @@
+package nlu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TupleFailure describes a single raw tuple that could not be parsed
+type TupleFailure struct {
+	Tuple  string `json:"tuple"`
+	Reason string `json:"reason"`
+}
+
+// ParseError is returned in strict mode when the model output was not parsed cleanly
+// Callers can branch on it with errors.As
+type ParseError struct {
+	Failures       []TupleFailure
+	CompletionSeen bool
+}
+
+func (e *ParseError) Error() string {
+	var reasons []string
+	if !e.CompletionSeen {
+		reasons = append(reasons, "completion delimiter missing")
+	}
+	if len(e.Failures) > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d tuple(s) failed, first: %s", len(e.Failures), e.Failures[0].Reason))
+	}
+	return "nlu parse error: " + strings.Join(reasons, "; ")
+}
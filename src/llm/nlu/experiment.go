@@ -0,0 +1,270 @@
+package nlu
+
+import (
+	"bufio"
+	"eino_llm_poc/src/model"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	einomodel "github.com/cloudwego/eino/components/model"
+	"gopkg.in/yaml.v2"
+)
+
+// ExperimentOutcomeMessage is the log message of experiment outcome events, read back by cmd/expreport
+const ExperimentOutcomeMessage = "NLU experiment outcome"
+
+// Experiment splits live traffic between prompt and model variants
+//
+//	name: prompt-v2-vs-v3
+//	variants:
+//	  - name: control        # empty fields keep the NLU_* configuration
+//	    weight: 50
+//	  - name: v2-mini
+//	    weight: 50
+//	    prompt_version: v2
+//	    model: openai/gpt-4o-mini
+//	    temperature: 0.2
+type Experiment struct {
+	Name     string              `yaml:"name"`
+	Variants []ExperimentVariant `yaml:"variants"`
+
+	totalWeight uint64
+}
+
+// ExperimentVariant is one arm of an experiment, empty fields keep the configured value
+type ExperimentVariant struct {
+	Name          string   `yaml:"name"`
+	Weight        int      `yaml:"weight"`
+	PromptVersion string   `yaml:"prompt_version,omitempty"`
+	Model         string   `yaml:"model,omitempty"`
+	Temperature   *float32 `yaml:"temperature,omitempty"`
+}
+
+// LoadExperiment reads and validates an experiment from a YAML file
+func LoadExperiment(path string) (*Experiment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read experiment file: %v", err)
+	}
+	var experiment Experiment
+	if err := yaml.UnmarshalStrict(data, &experiment); err != nil {
+		return nil, fmt.Errorf("failed to parse experiment file: %v", err)
+	}
+	if err := experiment.validate(); err != nil {
+		return nil, err
+	}
+	return &experiment, nil
+}
+
+// validate checks names and weights and sums the weights for assignment
+func (e *Experiment) validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("experiment has no name")
+	}
+	if len(e.Variants) == 0 {
+		return fmt.Errorf("experiment %s defines no variants", e.Name)
+	}
+	seen := make(map[string]bool)
+	e.totalWeight = 0
+	for _, variant := range e.Variants {
+		if variant.Name == "" {
+			return fmt.Errorf("experiment %s has a variant without a name", e.Name)
+		}
+		if seen[variant.Name] {
+			return fmt.Errorf("experiment %s defines variant %s twice", e.Name, variant.Name)
+		}
+		seen[variant.Name] = true
+		if variant.Weight < 0 {
+			return fmt.Errorf("variant %s weight must not be negative, got %d", variant.Name, variant.Weight)
+		}
+		if variant.Temperature != nil && (*variant.Temperature < 0 || *variant.Temperature > 2) {
+			return fmt.Errorf("variant %s temperature must be within [0, 2], got %v", variant.Name, *variant.Temperature)
+		}
+		e.totalWeight += uint64(variant.Weight)
+	}
+	if e.totalWeight == 0 {
+		return fmt.Errorf("experiment %s variants have no weight", e.Name)
+	}
+	return nil
+}
+
+// Assign returns the variant of a customer, nil without an experiment
+// The assignment hashes the experiment name with the customer ID, so a customer keeps
+// its variant across requests and restarts while the experiment is unchanged
+func (e *Experiment) Assign(customerID string) *ExperimentVariant {
+	if e == nil || e.totalWeight == 0 {
+		return nil
+	}
+	h := fnv.New64a()
+	h.Write([]byte(e.Name + "/" + customerID))
+	bucket := h.Sum64() % e.totalWeight
+	for i := range e.Variants {
+		weight := uint64(e.Variants[i].Weight)
+		if bucket < weight {
+			return &e.Variants[i]
+		}
+		bucket -= weight
+	}
+	return &e.Variants[len(e.Variants)-1]
+}
+
+// ModelOptions returns the model call options of the variant, nil for a nil variant
+// Pass them to every call made for a request of the variant, continuations included
+func (v *ExperimentVariant) ModelOptions() []einomodel.Option {
+	if v == nil {
+		return nil
+	}
+	var opts []einomodel.Option
+	if v.Model != "" {
+		opts = append(opts, einomodel.WithModel(v.Model))
+	}
+	if v.Temperature != nil {
+		opts = append(opts, einomodel.WithTemperature(*v.Temperature))
+	}
+	return opts
+}
+
+// ExperimentOutcome is the logged result of one request served by a variant
+type ExperimentOutcome struct {
+	Experiment     string  `json:"experiment"`
+	Variant        string  `json:"variant"`
+	CustomerID     string  `json:"customer_id"`
+	Status         string  `json:"status"` // parse status, error when the request failed
+	LatencyMs      float64 `json:"latency_ms"`
+	PrimaryIntent  string  `json:"primary_intent,omitempty"`
+	Confidence     float64 `json:"confidence"` // confidence of the primary intent
+	RepairAttempts int     `json:"repair_attempts"`
+}
+
+// NewExperimentOutcome summarizes the response of a request, response is nil when the request failed
+func NewExperimentOutcome(experiment *Experiment, variant *ExperimentVariant, customerID string, response *model.NLUResponse, latency time.Duration) ExperimentOutcome {
+	outcome := ExperimentOutcome{
+		Experiment: experiment.Name,
+		Variant:    variant.Name,
+		CustomerID: customerID,
+		Status:     "error",
+		LatencyMs:  float64(latency.Microseconds()) / 1000,
+	}
+	if response == nil {
+		return outcome
+	}
+	if status, ok := response.ParsingMetadata["status"].(string); ok {
+		outcome.Status = status
+	}
+	if attempts, ok := response.ParsingMetadata["repair_attempts"].(int); ok {
+		outcome.RepairAttempts = attempts
+	}
+	outcome.PrimaryIntent = response.PrimaryIntent
+	for _, intent := range response.Intents {
+		if intent.Name == response.PrimaryIntent {
+			outcome.Confidence = intent.Confidence
+			break
+		}
+	}
+	return outcome
+}
+
+// ReadExperimentOutcomes reads the outcome events of JSON log lines, other lines are skipped
+func ReadExperimentOutcomes(r io.Reader) ([]ExperimentOutcome, error) {
+	var outcomes []ExperimentOutcome
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var line struct {
+			Message string             `json:"message"`
+			Outcome *ExperimentOutcome `json:"outcome"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			continue
+		}
+		if line.Message == ExperimentOutcomeMessage && line.Outcome != nil {
+			outcomes = append(outcomes, *line.Outcome)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log: %v", err)
+	}
+	return outcomes, nil
+}
+
+// VariantReport aggregates the outcomes of one experiment variant
+type VariantReport struct {
+	Experiment    string
+	Variant       string
+	Requests      int
+	SuccessRate   float64 // share of requests parsed without failures
+	ErrorRate     float64 // share of requests without a response
+	RepairRate    float64 // share of requests that needed a repair round
+	LatencyMean   float64
+	LatencyP50    float64
+	LatencyP95    float64
+	ConfidenceP50 float64
+	// ConfidenceHistogram counts primary intent confidences in [0, 0.2), [0.2, 0.4), ... [0.8, 1]
+	ConfidenceHistogram [5]int
+}
+
+// SummarizeOutcomes builds the report of every variant, ordered by experiment and variant
+func SummarizeOutcomes(outcomes []ExperimentOutcome) []VariantReport {
+	grouped := make(map[[2]string][]ExperimentOutcome)
+	for _, outcome := range outcomes {
+		key := [2]string{outcome.Experiment, outcome.Variant}
+		grouped[key] = append(grouped[key], outcome)
+	}
+
+	reports := make([]VariantReport, 0, len(grouped))
+	for key, group := range grouped {
+		report := VariantReport{Experiment: key[0], Variant: key[1], Requests: len(group)}
+		var latencies, confidences []float64
+		for _, outcome := range group {
+			switch outcome.Status {
+			case "success":
+				report.SuccessRate++
+			case "error":
+				report.ErrorRate++
+			}
+			if outcome.RepairAttempts > 0 {
+				report.RepairRate++
+			}
+			latencies = append(latencies, outcome.LatencyMs)
+			report.LatencyMean += outcome.LatencyMs
+			if outcome.Status != "error" {
+				confidences = append(confidences, outcome.Confidence)
+				report.ConfidenceHistogram[min(int(outcome.Confidence*5), 4)]++
+			}
+		}
+		n := float64(len(group))
+		report.SuccessRate /= n
+		report.ErrorRate /= n
+		report.RepairRate /= n
+		report.LatencyMean /= n
+		report.LatencyP50 = percentile(latencies, 0.5)
+		report.LatencyP95 = percentile(latencies, 0.95)
+		report.ConfidenceP50 = percentile(confidences, 0.5)
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Experiment != reports[j].Experiment {
+			return reports[i].Experiment < reports[j].Experiment
+		}
+		return reports[i].Variant < reports[j].Variant
+	})
+	return reports
+}
+
+// percentile returns the nearest-rank percentile of values, 0 when empty
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
@@ -0,0 +1,293 @@
+package nlu
+
+import (
+	"bufio"
+	"eino_llm_poc/src/model"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Few-shot retrieval defaults
+const (
+	// DefaultFewShotExamples is the number of examples retrieved per query
+	DefaultFewShotExamples = 3
+
+	// BM25 parameters of the character n-gram retriever
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// fewShotNGramSizes are the character n-gram lengths indexed by the retriever
+// Character n-grams need no word segmentation, which Thai text does not have
+var fewShotNGramSizes = []int{2, 3}
+
+// FewShotExample is a labeled utterance of the example bank
+// The labels use the JSON output mode layout, so JSON mode output can be added with a text field
+type FewShotExample struct {
+	Text             string                  `json:"text"`
+	Intents          []model.Intent          `json:"intents"`
+	Entities         []model.Entity          `json:"entities"`
+	Languages        []model.Language        `json:"languages"`
+	Sentiment        model.Sentiment         `json:"sentiment"`
+	AspectSentiments []model.AspectSentiment `json:"aspect_sentiments,omitempty"`
+}
+
+// ScoredExample is a retrieved example with its BM25 score
+type ScoredExample struct {
+	FewShotExample
+	Score float64
+}
+
+// ExampleBank holds labeled examples indexed for BM25 retrieval over character n-grams
+type ExampleBank struct {
+	Examples []FewShotExample
+
+	termFreqs    []map[string]int
+	docLengths   []int
+	docFreqs     map[string]int
+	avgDocLength float64
+}
+
+// LoadExampleBank reads labeled examples from a JSONL file, one example per line
+func LoadExampleBank(path string) (*ExampleBank, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open example bank: %v", err)
+	}
+	defer file.Close()
+
+	var examples []FewShotExample
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var example FewShotExample
+		if err := json.Unmarshal([]byte(line), &example); err != nil {
+			return nil, fmt.Errorf("example bank line %d: %v", lineNumber, err)
+		}
+		if strings.TrimSpace(example.Text) == "" || len(example.Intents) == 0 {
+			return nil, fmt.Errorf("example bank line %d: text and at least one intent are required", lineNumber)
+		}
+		examples = append(examples, example)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read example bank: %v", err)
+	}
+	return NewExampleBank(examples), nil
+}
+
+// NewExampleBank indexes the examples for retrieval
+func NewExampleBank(examples []FewShotExample) *ExampleBank {
+	b := &ExampleBank{
+		Examples:   examples,
+		termFreqs:  make([]map[string]int, len(examples)),
+		docLengths: make([]int, len(examples)),
+		docFreqs:   make(map[string]int),
+	}
+	total := 0
+	for i, example := range examples {
+		terms := charNGrams(example.Text)
+		freqs := make(map[string]int, len(terms))
+		for _, term := range terms {
+			freqs[term]++
+		}
+		for term := range freqs {
+			b.docFreqs[term]++
+		}
+		b.termFreqs[i] = freqs
+		b.docLengths[i] = len(terms)
+		total += len(terms)
+	}
+	if len(examples) > 0 {
+		b.avgDocLength = float64(total) / float64(len(examples))
+	}
+	return b
+}
+
+// Retrieve returns the k examples most similar to the query, best first
+// Examples sharing no n-gram with the query are never returned
+func (b *ExampleBank) Retrieve(query string, k int) []ScoredExample {
+	if b == nil || k <= 0 || len(b.Examples) == 0 {
+		return nil
+	}
+
+	queryTerms := make(map[string]bool)
+	for _, term := range charNGrams(query) {
+		queryTerms[term] = true
+	}
+
+	docs := float64(len(b.Examples))
+	var scored []ScoredExample
+	for i, freqs := range b.termFreqs {
+		score := 0.0
+		for term := range queryTerms {
+			tf := float64(freqs[term])
+			if tf == 0 {
+				continue
+			}
+			df := float64(b.docFreqs[term])
+			idf := math.Log(1 + (docs-df+0.5)/(df+0.5))
+			norm := 1 - bm25B + bm25B*float64(b.docLengths[i])/b.avgDocLength
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+		if score > 0 {
+			scored = append(scored, ScoredExample{FewShotExample: b.Examples[i], Score: score})
+		}
+	}
+
+	sort.SliceStable(scored, func(a, c int) bool { return scored[a].Score > scored[c].Score })
+	if len(scored) > k {
+		scored = scored[:k]
+	}
+	return scored
+}
+
+// charNGrams splits text into lowercase words and returns their character n-grams
+// Words shorter than an n-gram size contribute themselves as a term
+func charNGrams(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(replaceThaiDigits(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	})
+
+	var terms []string
+	for _, word := range words {
+		runes := []rune(word)
+		for _, n := range fewShotNGramSizes {
+			if len(runes) < n {
+				if n == fewShotNGramSizes[0] {
+					terms = append(terms, word)
+				}
+				continue
+			}
+			for i := 0; i+n <= len(runes); i++ {
+				terms = append(terms, string(runes[i:i+n]))
+			}
+		}
+	}
+	return terms
+}
+
+// RenderFewShot renders retrieved examples in the output format of the processor, bound to the
+// Examples variable of the system prompt in place of its static examples
+// Tuple mode examples use the configured delimiters, JSON mode examples the JSON output layout;
+// tools mode has no textual output format and renders nothing.
+// Intent priorities are taken from the catalog and missing entity positions are computed from the text
+func (n *NLUProcessor) RenderFewShot(examples []ScoredExample) string {
+	if len(examples) == 0 || n.config.OutputMode == OutputModeTools {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("<examples>\nLabeled messages similar to the current one, follow their labeling:\n")
+	for i, scored := range examples {
+		example := n.completeExample(scored.FewShotExample)
+		fmt.Fprintf(&b, "\n**Example %d:**\ntext: %s\n\nOutput:\n", i+1, example.Text)
+		if n.config.OutputMode == OutputModeJSON {
+			data, _ := json.Marshal(jsonNLUOutput{
+				Intents:          example.Intents,
+				Entities:         example.Entities,
+				Languages:        example.Languages,
+				Sentiment:        &example.Sentiment,
+				AspectSentiments: example.AspectSentiments,
+			})
+			b.Write(data)
+			b.WriteString("\n")
+			continue
+		}
+		for _, tuple := range exampleTuples(example) {
+			b.WriteString("(" + strings.Join(tuple.Parts, n.config.TupleDelimiter) + ")" + n.config.RecordDelimiter + "\n")
+		}
+		b.WriteString(n.config.CompletionDelimiter + "\n")
+	}
+	b.WriteString("</examples>")
+	return b.String()
+}
+
+// completeExample fills catalog priorities, entity positions and empty metadata of an example
+func (n *NLUProcessor) completeExample(example FewShotExample) FewShotExample {
+	example.Intents = append([]model.Intent(nil), example.Intents...)
+	for i := range example.Intents {
+		intent := &example.Intents[i]
+		if n.catalog != nil {
+			if allowed, _, ok := n.catalog.MatchIntent(intent.Name); ok {
+				intent.Priority = allowed.Priority
+			}
+		}
+		if intent.Metadata == nil {
+			intent.Metadata = map[string]any{}
+		}
+	}
+
+	example.Entities = append([]model.Entity(nil), example.Entities...)
+	for i := range example.Entities {
+		entity := &example.Entities[i]
+		if len(entity.Position) != 2 {
+			entity.Position = spanPosition(example.Text, entity.Value)
+		}
+		if entity.Metadata == nil {
+			entity.Metadata = map[string]any{}
+		}
+	}
+
+	example.AspectSentiments = append([]model.AspectSentiment(nil), example.AspectSentiments...)
+	for i := range example.AspectSentiments {
+		aspect := &example.AspectSentiments[i]
+		if len(aspect.Position) != 2 {
+			aspect.Position = spanPosition(example.Text, aspect.Span)
+		}
+		if aspect.Metadata == nil {
+			aspect.Metadata = map[string]any{}
+		}
+	}
+
+	example.Languages = append([]model.Language(nil), example.Languages...)
+	for i := range example.Languages {
+		if example.Languages[i].Metadata == nil {
+			example.Languages[i].Metadata = map[string]any{}
+		}
+	}
+	if example.Sentiment.Metadata == nil {
+		example.Sentiment.Metadata = map[string]any{}
+	}
+	return example
+}
+
+// spanPosition returns the rune offsets of the first occurrence of span in text, nil when absent
+func spanPosition(text, span string) []int {
+	start := strings.Index(text, span)
+	if span == "" || start < 0 {
+		return nil
+	}
+	runeStart := utf8.RuneCountInString(text[:start])
+	return []int{runeStart, runeStart + utf8.RuneCountInString(span)}
+}
+
+// exampleTuples converts a labeled example into tuples in prompt order
+func exampleTuples(example FewShotExample) []*RawTuple {
+	var tuples []*RawTuple
+	for _, intent := range example.Intents {
+		tuples = append(tuples, intentTuple(intent))
+	}
+	for _, entity := range example.Entities {
+		tuples = append(tuples, entityTuple(entity))
+	}
+	for _, language := range example.Languages {
+		tuples = append(tuples, languageTuple(language))
+	}
+	if example.Sentiment.Label != "" {
+		tuples = append(tuples, sentimentTuple(example.Sentiment))
+	}
+	for _, aspect := range example.AspectSentiments {
+		tuples = append(tuples, aspectSentimentTuple(aspect))
+	}
+	return tuples
+}
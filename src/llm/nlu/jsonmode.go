@@ -0,0 +1,294 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/llm/chatmodel"
+	"eino_llm_poc/src/model"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// OutputMode selects the format the NLU model is asked to produce
+type OutputMode string
+
+const (
+	// OutputModeTuple uses the delimited (type<||>...)## tuple format
+	OutputModeTuple OutputMode = "tuple"
+	// OutputModeJSON uses structured JSON output constrained by a JSON Schema
+	OutputModeJSON OutputMode = "json"
+)
+
+// JSON output schema name and description sent in the response_format of the ChatModel
+const (
+	JSONSchemaName        = "nlu_response"
+	JSONSchemaDescription = "Structured NLU analysis of the current user message"
+)
+
+// jsonOutputFields are the NLUResponse fields produced by the model, derived fields are computed locally
+var jsonOutputFields = []string{"intents", "entities", "languages", "sentiment", "aspect_sentiments"}
+
+// jsonNLUOutput mirrors the model-produced part of model.NLUResponse
+type jsonNLUOutput struct {
+	Intents          []model.Intent          `json:"intents"`
+	Entities         []model.Entity          `json:"entities"`
+	Languages        []model.Language        `json:"languages"`
+	Sentiment        *model.Sentiment        `json:"sentiment"`
+	AspectSentiments []model.AspectSentiment `json:"aspect_sentiments"`
+}
+
+// ResolveOutputMode returns the output mode for a model
+// NLU_OUTPUT_MODE_OVERRIDES entries ("vendor/model:json") take precedence over NLU_OUTPUT_MODE
+func ResolveOutputMode(nluConfig *model.NLUConfig, modelName string) OutputMode {
+	for _, item := range splitList(nluConfig.OutputModeOverrides) {
+		// Model names may contain ':' themselves (e.g. ":free" variants), so split on the last one
+		idx := strings.LastIndex(item, ":")
+		if idx <= 0 {
+			continue
+		}
+		if strings.TrimSpace(item[:idx]) == modelName {
+			return OutputMode(strings.TrimSpace(item[idx+1:]))
+		}
+	}
+	if nluConfig.OutputMode == "" {
+		return OutputModeTuple
+	}
+	return OutputMode(nluConfig.OutputMode)
+}
+
+// NLUResponseJSONSchema generates the JSON Schema of the model-produced NLUResponse fields
+// When a catalog is given, intent names and entity types are restricted to its labels
+func NLUResponseJSONSchema(catalog *Catalog) *openapi3.Schema {
+	full := schemaForType(reflect.TypeOf(model.NLUResponse{}))
+
+	output := openapi3.NewObjectSchema()
+	for _, field := range jsonOutputFields {
+		output.WithPropertyRef(field, full.Properties[field])
+	}
+	output.Required = jsonOutputFields
+	output.AdditionalProperties = openapi3.AdditionalProperties{Has: new(bool)}
+
+	sentiment := output.Properties["sentiment"].Value
+	sentiment.Properties["label"].Value.WithEnum("positive", "neutral", "negative")
+	itemProperties(output, "aspect_sentiments")["label"].Value.WithEnum("positive", "neutral", "negative")
+	// Normalized values are computed locally from the entity value
+	delete(itemProperties(output, "entities"), "normalized")
+
+	if catalog != nil {
+		itemProperties(output, "intents")["name"].Value.WithEnum(catalog.intentNames()...)
+		itemProperties(output, "entities")["type"].Value.WithEnum(catalog.entityNames()...)
+		itemProperties(output, "aspect_sentiments")["aspect"].Value.WithEnum(catalog.entityNames()...)
+	}
+
+	return output
+}
+
+// itemProperties returns the item properties of an array property
+func itemProperties(schema *openapi3.Schema, field string) openapi3.Schemas {
+	return schema.Properties[field].Value.Items.Value.Properties
+}
+
+// schemaForType builds a JSON Schema for a Go type from its json struct tags
+func schemaForType(t reflect.Type) *openapi3.Schema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return openapi3.NewDateTimeSchema()
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaForType(t.Elem())
+	case reflect.String:
+		return openapi3.NewStringSchema()
+	case reflect.Bool:
+		return openapi3.NewBoolSchema()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openapi3.NewIntegerSchema()
+	case reflect.Float32, reflect.Float64:
+		return openapi3.NewFloat64Schema()
+	case reflect.Slice, reflect.Array:
+		return openapi3.NewArraySchema().WithItems(schemaForType(t.Elem()))
+	case reflect.Map:
+		return openapi3.NewObjectSchema()
+	case reflect.Struct:
+		schema := openapi3.NewObjectSchema()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			schema.WithProperty(name, schemaForType(field.Type))
+			if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Map {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		return schema
+	default:
+		return openapi3.NewSchema()
+	}
+}
+
+// JSONSchemaOption returns the ChatModel option constraining output to the JSON mode schema
+func JSONSchemaOption(catalog *Catalog) chatmodel.Option {
+	return chatmodel.WithJSONSchema(JSONSchemaName, JSONSchemaDescription, NLUResponseJSONSchema(catalog))
+}
+
+// ParseJSONResponse decodes JSON model output into structured NLUResponse
+// Every item goes through the same validation as its tuple counterpart
+func (n *NLUProcessor) ParseJSONResponse(content string) (*model.NLUResponse, error) {
+	session := n.newParseSession()
+	session.addJSON(content)
+	return session.finalize()
+}
+
+// addJSON decodes a complete JSON document and adds its items to the session
+// A successfully decoded document counts as the completion signal of JSON mode
+func (s *parseSession) addJSON(content string) {
+	content = strings.TrimSpace(content)
+	if s.processor.config.FormatRecovery {
+		content = s.extractJSONObject(content)
+	}
+
+	var output jsonNLUOutput
+	if err := json.Unmarshal([]byte(content), &output); err != nil {
+		s.track(truncate(content, MaxTupleLength), nil, fmt.Errorf("failed to decode JSON output: %v", err))
+		return
+	}
+	s.completionSeen = true
+
+	var tuples []*RawTuple
+	for _, intent := range output.Intents {
+		tuples = append(tuples, intentTuple(intent))
+	}
+	for _, entity := range output.Entities {
+		tuples = append(tuples, entityTuple(entity))
+	}
+	for _, language := range output.Languages {
+		tuples = append(tuples, languageTuple(language))
+	}
+	if output.Sentiment != nil {
+		tuples = append(tuples, sentimentTuple(*output.Sentiment))
+	}
+	for _, aspect := range output.AspectSentiments {
+		tuples = append(tuples, aspectSentimentTuple(aspect))
+	}
+
+	for _, tuple := range tuples {
+		parser, err := s.addTuple(tuple)
+		s.track(strings.Join(tuple.Parts, s.processor.config.TupleDelimiter), parser, err)
+	}
+}
+
+// intentTuple converts a structured intent into its tuple form
+func intentTuple(intent model.Intent) *RawTuple {
+	return &RawTuple{Type: "intent", Parts: []string{
+		"intent", intent.Name, formatFloat(intent.Confidence), formatFloat(intent.Priority), metadataString(intent.Metadata),
+	}}
+}
+
+// entityTuple converts a structured entity into its tuple form, moving Position into entity_position
+func entityTuple(entity model.Entity) *RawTuple {
+	metadata := entity.Metadata
+	if len(entity.Position) == 2 {
+		metadata = cloneMetadata(metadata)
+		metadata["entity_position"] = []any{float64(entity.Position[0]), float64(entity.Position[1])}
+	}
+	return &RawTuple{Type: "entity", Parts: []string{
+		"entity", entity.Type, entity.Value, formatFloat(entity.Confidence), metadataString(metadata),
+	}}
+}
+
+// languageTuple converts a structured language into its tuple form
+func languageTuple(language model.Language) *RawTuple {
+	primaryFlag := "0"
+	if language.IsPrimary {
+		primaryFlag = "1"
+	}
+	return &RawTuple{Type: "language", Parts: []string{
+		"language", language.Code, formatFloat(language.Confidence), primaryFlag, metadataString(language.Metadata),
+	}}
+}
+
+// sentimentTuple converts a structured sentiment into its tuple form
+func sentimentTuple(sentiment model.Sentiment) *RawTuple {
+	return &RawTuple{Type: "sentiment", Parts: []string{
+		"sentiment", sentiment.Label, formatFloat(sentiment.Confidence), metadataString(sentiment.Metadata),
+	}}
+}
+
+// aspectSentimentTuple converts a structured aspect sentiment into its tuple form, moving Position into entity_position
+func aspectSentimentTuple(aspect model.AspectSentiment) *RawTuple {
+	metadata := aspect.Metadata
+	if len(aspect.Position) == 2 {
+		metadata = cloneMetadata(metadata)
+		metadata["entity_position"] = []any{float64(aspect.Position[0]), float64(aspect.Position[1])}
+	}
+	return &RawTuple{Type: "aspect_sentiment", Parts: []string{
+		"aspect_sentiment", aspect.Aspect, aspect.Span, aspect.Label, formatFloat(aspect.Confidence), metadataString(metadata),
+	}}
+}
+
+// extractJSONObject strips code fences and surrounding prose around the JSON object
+func (s *parseSession) extractJSONObject(content string) string {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return content
+	}
+	if start > 0 {
+		if strings.Contains(content[:start], "```") {
+			s.addFixes(FixCodeFence)
+		} else {
+			s.skippedCount++
+		}
+	}
+	if end < len(content)-1 {
+		s.addFixes(FixTrailingText)
+	}
+	return content[start : end+1]
+}
+
+// formatFloat renders a float without losing precision for re-parsing
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// metadataString serializes metadata to the JSON object form used in tuples
+func metadataString(metadata map[string]any) string {
+	if len(metadata) == 0 {
+		return "{}"
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// cloneMetadata returns a shallow copy of metadata that is safe to modify
+func cloneMetadata(metadata map[string]any) map[string]any {
+	cloned := make(map[string]any, len(metadata)+1)
+	for key, value := range metadata {
+		cloned[key] = value
+	}
+	return cloned
+}
+
+// truncate shortens s to at most maxLength bytes for diagnostics
+func truncate(s string, maxLength int) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxLength], "")
+}
@@ -0,0 +1,347 @@
+package nlu
+
+import (
+	"bufio"
+	"eino_llm_poc/src/model"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/language"
+)
+
+// Script detection thresholds for correcting PrimaryLanguage
+const (
+	// dominantScriptShare is the share of letters a script needs to overrule the model
+	dominantScriptShare = 0.6
+	// minorScriptShare is the share below which the model's primary language is considered absent
+	minorScriptShare = 0.2
+)
+
+// Script names used in language metadata and ParsingMetadata["script_tokens"]
+const (
+	ScriptLatin = "latin"
+	ScriptThai  = "thai"
+	ScriptHan   = "han"
+	ScriptKana  = "kana"
+)
+
+//go:embed iso639.tsv
+var iso639Data string
+
+// LanguageInfo is a language of the embedded ISO 639 table or the language subtag registry
+type LanguageInfo struct {
+	Code    string   // ISO 639-3 code
+	Alpha2  string   // ISO 639-1 code, empty when the language has none
+	Scripts []string // writing scripts, empty for special codes such as und and mul
+	Name    string   // English name, empty for languages outside the embedded table
+}
+
+// languageTable maps ISO 639-3 codes, ISO 639-1 and 639-2/B aliases and lowercase names of the
+// embedded table to languages; it is parsed on first use
+var languageTable = sync.OnceValues(func() (map[string]LanguageInfo, error) {
+	return loadLanguageTable(iso639Data)
+})
+
+// languageCodeShape matches well-formed ISO 639-3 codes
+var languageCodeShape = regexp.MustCompile(`^[a-z]{3}$`)
+
+// registryScripts maps the ISO 15924 likely scripts of the language subtag registry to detected scripts
+var registryScripts = map[string][]string{
+	"Latn": {ScriptLatin}, "Thai": {ScriptThai}, "Hani": {ScriptHan}, "Hans": {ScriptHan}, "Hant": {ScriptHan},
+	"Jpan": {ScriptHan, ScriptKana}, "Hira": {ScriptKana}, "Kana": {ScriptKana}, "Kore": {"hangul", ScriptHan},
+	"Hang": {"hangul"}, "Cyrl": {"cyrillic"}, "Arab": {"arabic"}, "Deva": {"devanagari"}, "Hebr": {"hebrew"},
+	"Grek": {"greek"}, "Laoo": {"lao"}, "Khmr": {"khmer"}, "Mymr": {"myanmar"}, "Beng": {"bengali"},
+	"Taml": {"tamil"}, "Telu": {"telugu"}, "Knda": {"kannada"}, "Mlym": {"malayalam"}, "Gujr": {"gujarati"},
+	"Guru": {"gurmukhi"}, "Orya": {"oriya"}, "Sinh": {"sinhala"}, "Tibt": {"tibetan"}, "Geor": {"georgian"},
+	"Armn": {"armenian"}, "Ethi": {"ethiopic"}, "Thaa": {"thaana"}, "Yiii": {"yi"}, "Cans": {"canadian_aboriginal"},
+}
+
+// scriptTables are the Unicode scripts the detector distinguishes
+var scriptTables = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{ScriptLatin, unicode.Latin}, {ScriptThai, unicode.Thai}, {ScriptHan, unicode.Han},
+	{ScriptKana, unicode.Hiragana}, {ScriptKana, unicode.Katakana}, {"hangul", unicode.Hangul},
+	{"cyrillic", unicode.Cyrillic}, {"arabic", unicode.Arabic}, {"devanagari", unicode.Devanagari},
+	{"hebrew", unicode.Hebrew}, {"greek", unicode.Greek}, {"lao", unicode.Lao}, {"khmer", unicode.Khmer},
+	{"myanmar", unicode.Myanmar}, {"bengali", unicode.Bengali}, {"tamil", unicode.Tamil},
+	{"telugu", unicode.Telugu}, {"kannada", unicode.Kannada}, {"malayalam", unicode.Malayalam},
+	{"gujarati", unicode.Gujarati}, {"gurmukhi", unicode.Gurmukhi}, {"oriya", unicode.Oriya},
+	{"sinhala", unicode.Sinhala}, {"tibetan", unicode.Tibetan}, {"georgian", unicode.Georgian},
+	{"armenian", unicode.Armenian}, {"ethiopic", unicode.Ethiopic}, {"thaana", unicode.Thaana},
+	{"yi", unicode.Yi}, {"canadian_aboriginal", unicode.Canadian_Aboriginal},
+}
+
+// scriptLanguages is the language assumed for text written only in a script
+// Latin defaults to English, the only Latin-script language our customers write
+var scriptLanguages = map[string]string{
+	ScriptLatin: "eng", ScriptThai: "tha", ScriptHan: "zho", ScriptKana: "jpn", "hangul": "kor",
+	"cyrillic": "rus", "arabic": "ara", "devanagari": "hin", "lao": "lao", "khmer": "khm", "myanmar": "mya",
+}
+
+// loadLanguageTable parses the embedded tab-separated ISO 639 table
+func loadLanguageTable(data string) (map[string]LanguageInfo, error) {
+	table := make(map[string]LanguageInfo)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		columns := strings.Split(line, "\t")
+		if len(columns) != 5 {
+			return nil, fmt.Errorf("invalid ISO 639 table line: %q", line)
+		}
+		info := LanguageInfo{Code: columns[0], Name: columns[4]}
+		if columns[1] != "-" {
+			info.Alpha2 = columns[1]
+		}
+		if columns[3] != "-" {
+			info.Scripts = strings.Split(columns[3], "+")
+		}
+
+		for _, key := range []string{columns[0], columns[1], columns[2], strings.ToLower(columns[4])} {
+			if key != "-" {
+				table[key] = info
+			}
+		}
+	}
+	return table, nil
+}
+
+// LookupLanguage returns the language for an ISO 639-3 code, an ISO 639-1 or 639-2/B alias
+// or an English name; region subtags as in "en-US" or "th_TH" are ignored
+// Codes missing from the embedded table are looked up in the language subtag registry of
+// golang.org/x/text, which covers all of ISO 639-3 but only knows the likely script of a language
+func LookupLanguage(code string) (LanguageInfo, bool) {
+	key := strings.ToLower(strings.TrimSpace(code))
+	if idx := strings.IndexAny(key, "-_"); idx > 0 {
+		if _, ok := lookupTable(key); !ok {
+			key = key[:idx]
+		}
+	}
+	if info, ok := lookupTable(key); ok {
+		return info, true
+	}
+	return lookupRegistry(key)
+}
+
+// lookupTable looks up a key of the embedded table, which is empty when it failed to parse
+func lookupTable(key string) (LanguageInfo, bool) {
+	table, _ := languageTable()
+	info, ok := table[key]
+	return info, ok
+}
+
+// lookupRegistry looks up a two or three letter code in the language subtag registry
+func lookupRegistry(code string) (LanguageInfo, bool) {
+	if len(code) != 2 && len(code) != 3 {
+		return LanguageInfo{}, false
+	}
+	base, err := language.ParseBase(code)
+	if err != nil {
+		return LanguageInfo{}, false
+	}
+	info := LanguageInfo{Code: base.ISO3()}
+	if alpha2 := base.String(); len(alpha2) == 2 {
+		info.Alpha2 = alpha2
+	}
+	if script, confidence := language.Make(base.String()).Script(); confidence != language.No {
+		info.Scripts = registryScripts[script.String()]
+	}
+	return info, true
+}
+
+// CanonicalLanguageCode converts a language code or alias to its ISO 639-3 code
+// It reports the parse error of the embedded table on every call, as the table is loaded lazily
+func CanonicalLanguageCode(code string) (string, error) {
+	if _, err := languageTable(); err != nil {
+		return "", err
+	}
+	info, ok := LookupLanguage(code)
+	if !ok {
+		return "", fmt.Errorf("unknown language code: %q", code)
+	}
+	return info.Code, nil
+}
+
+// isLanguageCodeShape reports whether code is a well-formed ISO 639-3 code, three lowercase letters
+func isLanguageCodeShape(code string) bool {
+	return languageCodeShape.MatchString(code)
+}
+
+// ScriptCounts counts the tokens and letters of each script in a text
+type ScriptCounts struct {
+	Tokens  map[string]int // runs of letters of one script, a Thai run without spaces is one token
+	Letters map[string]int
+}
+
+// DetectScripts counts tokens and letters per Unicode script
+// Combining marks count as letters of their base; other scripts, digits and punctuation are ignored
+func DetectScripts(text string) ScriptCounts {
+	counts := ScriptCounts{Tokens: make(map[string]int), Letters: make(map[string]int)}
+	previous := ""
+	for _, r := range text {
+		script := runeScript(r)
+		if script == "" {
+			// Combining marks belong to the current token, as Thai vowels and tone marks do
+			if unicode.Is(unicode.Mn, r) && previous != "" {
+				counts.Letters[previous]++
+			} else {
+				previous = ""
+			}
+			continue
+		}
+		counts.Letters[script]++
+		if script != previous {
+			counts.Tokens[script]++
+		}
+		previous = script
+	}
+	return counts
+}
+
+// runeScript returns the script of a letter, empty for other runes
+func runeScript(r rune) string {
+	if !unicode.IsLetter(r) {
+		return ""
+	}
+	for _, s := range scriptTables {
+		if unicode.Is(s.table, r) {
+			return s.name
+		}
+	}
+	return ""
+}
+
+// total returns the number of letters of the known scripts
+func (c ScriptCounts) total() int {
+	total := 0
+	for _, n := range c.Letters {
+		total += n
+	}
+	return total
+}
+
+// dominant returns the script with the most letters, ties broken by name
+func (c ScriptCounts) dominant() string {
+	scripts := make([]string, 0, len(c.Letters))
+	for script := range c.Letters {
+		scripts = append(scripts, script)
+	}
+	sort.Strings(scripts)
+	best := ""
+	for _, script := range scripts {
+		if best == "" || c.Letters[script] > c.Letters[best] {
+			best = script
+		}
+	}
+	return best
+}
+
+// languageShare returns the share of letters written in the language's scripts and their token count
+func (c ScriptCounts) languageShare(code string) (float64, int) {
+	info, ok := LookupLanguage(code)
+	total := c.total()
+	if !ok || total == 0 {
+		return 0, 0
+	}
+	letters, tokens := 0, 0
+	for _, script := range info.Scripts {
+		letters += c.Letters[script]
+		tokens += c.Tokens[script]
+	}
+	return float64(letters) / float64(total), tokens
+}
+
+// VerifyLanguages cross-checks the detected languages against the scripts of the analysed text
+//
+// Every language gets script_tokens and script_verified in its metadata, and the script counts
+// are written to ParsingMetadata["script_tokens"]. When the model's primary language is written in
+// a script barely present in the text while another script clearly dominates, PrimaryLanguage is
+// corrected to a language of the dominant script, added if the model did not report one, and the
+// change is recorded in ParsingMetadata["language_correction"].
+func VerifyLanguages(response *model.NLUResponse, text string) {
+	if response == nil {
+		return
+	}
+	counts := DetectScripts(text)
+	response.ParsingMetadata["script_tokens"] = counts.Tokens
+	if counts.total() == 0 {
+		return
+	}
+
+	for i := range response.Languages {
+		language := &response.Languages[i]
+		if language.Metadata == nil {
+			language.Metadata = make(map[string]any)
+		}
+		share, tokens := counts.languageShare(language.Code)
+		language.Metadata["script_tokens"] = tokens
+		language.Metadata["script_verified"] = share > 0
+	}
+
+	dominant := counts.dominant()
+	dominantShare := float64(counts.Letters[dominant]) / float64(counts.total())
+	primaryShare, _ := counts.languageShare(response.PrimaryLanguage)
+	if primaryShare >= minorScriptShare || dominantShare < dominantScriptShare {
+		return
+	}
+
+	corrected := correctedPrimaryLanguage(response, dominant, counts)
+	if corrected == "" || corrected == response.PrimaryLanguage {
+		return
+	}
+	response.ParsingMetadata["language_correction"] = map[string]any{
+		"original":     response.PrimaryLanguage,
+		"corrected":    corrected,
+		"script":       dominant,
+		"script_share": dominantShare,
+	}
+	for i := range response.Languages {
+		response.Languages[i].IsPrimary = response.Languages[i].Code == corrected
+	}
+	response.PrimaryLanguage = corrected
+}
+
+// correctedPrimaryLanguage picks the most confident reported language written in the script,
+// or adds the script's default language; empty when the script has no default
+func correctedPrimaryLanguage(response *model.NLUResponse, script string, counts ScriptCounts) string {
+	best := -1
+	for i, language := range response.Languages {
+		info, ok := LookupLanguage(language.Code)
+		if !ok || !slices.Contains(info.Scripts, script) {
+			continue
+		}
+		if best < 0 || language.Confidence > response.Languages[best].Confidence {
+			best = i
+		}
+	}
+	if best >= 0 {
+		return response.Languages[best].Code
+	}
+
+	code, ok := scriptLanguages[script]
+	if !ok {
+		return ""
+	}
+	response.Languages = append(response.Languages, model.Language{
+		Code:       code,
+		Confidence: float64(counts.Letters[script]) / float64(counts.total()),
+		Metadata: map[string]any{
+			"script":          script,
+			"detected_tokens": counts.Tokens[script],
+			"script_tokens":   counts.Tokens[script],
+			"script_verified": true,
+			"source":          "script_detection",
+		},
+	})
+	return code
+}
@@ -0,0 +1,351 @@
+package nlu
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Entity linking defaults
+const (
+	// DefaultLinkThreshold is the minimum score for linking an entity to a product
+	DefaultLinkThreshold = 0.7
+	// DefaultLinkCandidates is the number of candidates attached to an entity
+	DefaultLinkCandidates = 3
+
+	// phoneticDiscount lowers transliteration matches, consonant skeletons are lossy
+	phoneticDiscount = 0.9
+	// minPhoneticKeyLength is the shortest consonant skeleton compared phonetically
+	// Shorter skeletons ("sony" -> "sn") only count when equal, scored shortPhoneticScore
+	minPhoneticKeyLength = 3
+	shortPhoneticScore   = 0.8
+	// minCandidateScore drops unrelated products from the candidate list
+	minCandidateScore = 0.4
+)
+
+// Product fields an entity value is matched against
+const (
+	ProductFieldName  = "name"
+	ProductFieldBrand = "brand"
+	ProductFieldModel = "model"
+)
+
+// Product is an entry of the product catalog
+type Product struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Brand   string   `json:"brand,omitempty"`
+	Model   string   `json:"model,omitempty"`
+	Aliases []string `json:"aliases,omitempty"` // alternative names, e.g. Thai spellings and abbreviations
+}
+
+// ProductCatalog holds the products entities are linked to
+type ProductCatalog struct {
+	Products []Product
+}
+
+// LinkCandidate is a catalog product an entity value may refer to
+type LinkCandidate struct {
+	ProductID string  `json:"product_id"`
+	Name      string  `json:"name"`
+	Matched   string  `json:"matched"` // catalog text the value matched
+	Score     float64 `json:"score"`
+}
+
+// LoadProductCatalog reads a product catalog from a .json or .csv file
+// JSON files hold an array of products; CSV files need an id,name,brand,model,aliases header,
+// aliases separated by "|"
+func LoadProductCatalog(path string) (*ProductCatalog, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open product catalog: %v", err)
+	}
+	defer file.Close()
+
+	var products []Product
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.NewDecoder(file).Decode(&products); err != nil {
+			return nil, fmt.Errorf("failed to parse product catalog: %v", err)
+		}
+	case ".csv":
+		if products, err = readProductsCSV(file); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported product catalog format: %s", path)
+	}
+
+	for i, product := range products {
+		if strings.TrimSpace(product.ID) == "" || strings.TrimSpace(product.Name) == "" {
+			return nil, fmt.Errorf("product %d requires an id and a name", i+1)
+		}
+	}
+	return &ProductCatalog{Products: products}, nil
+}
+
+// readProductsCSV reads products from CSV with a header row, columns may be in any order
+func readProductsCSV(r io.Reader) ([]Product, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse product catalog: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"id", "name"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("product catalog is missing the %s column", required)
+		}
+	}
+	field := func(record []string, column string) string {
+		if idx, ok := columns[column]; ok && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+
+	products := make([]Product, 0, len(records)-1)
+	for _, record := range records[1:] {
+		product := Product{
+			ID:    field(record, "id"),
+			Name:  field(record, "name"),
+			Brand: field(record, "brand"),
+			Model: field(record, "model"),
+		}
+		for _, alias := range strings.Split(field(record, "aliases"), "|") {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				product.Aliases = append(product.Aliases, alias)
+			}
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+// EntityLinker links product, brand and model entities to catalog products
+type EntityLinker struct {
+	Threshold     float64
+	MaxCandidates int
+	// EntityFields maps entity types to the product fields their values are matched against
+	EntityFields map[string][]string
+
+	catalog *ProductCatalog
+	keys    [][]linkKey // match keys per product
+}
+
+// linkKey is a precomputed form of a product field value
+type linkKey struct {
+	field    string
+	text     string // original catalog text
+	compact  string // lowercase letters and digits only
+	phonetic string // consonant skeleton shared by Thai and Latin spellings
+}
+
+// NewEntityLinker creates a linker over the product catalog
+func NewEntityLinker(catalog *ProductCatalog) *EntityLinker {
+	l := &EntityLinker{
+		Threshold:     DefaultLinkThreshold,
+		MaxCandidates: DefaultLinkCandidates,
+		EntityFields: map[string][]string{
+			"product": {ProductFieldName, ProductFieldModel},
+			"brand":   {ProductFieldBrand},
+			"model":   {ProductFieldModel, ProductFieldName},
+		},
+		catalog: catalog,
+	}
+	for _, product := range catalog.Products {
+		var keys []linkKey
+		add := func(field, text string) {
+			if strings.TrimSpace(text) != "" {
+				keys = append(keys, linkKey{field: field, text: text, compact: compactText(text), phonetic: phoneticKey(text)})
+			}
+		}
+		add(ProductFieldName, product.Name)
+		for _, alias := range product.Aliases {
+			add(ProductFieldName, alias)
+		}
+		if product.Brand != "" && product.Model != "" {
+			add(ProductFieldName, product.Brand+" "+product.Model)
+		}
+		add(ProductFieldBrand, product.Brand)
+		add(ProductFieldModel, product.Model)
+		l.keys = append(l.keys, keys)
+	}
+	return l
+}
+
+// Link returns the best matching products for an entity value, highest score first
+// Returns nil for entity types the linker does not handle
+func (l *EntityLinker) Link(entityType, value string) []LinkCandidate {
+	fields, ok := l.EntityFields[entityType]
+	if !ok {
+		return nil
+	}
+	compact, phonetic := compactText(value), phoneticKey(value)
+	if compact == "" {
+		return nil
+	}
+
+	var candidates []LinkCandidate
+	for i, keys := range l.keys {
+		best := LinkCandidate{ProductID: l.catalog.Products[i].ID, Name: l.catalog.Products[i].Name}
+		for _, key := range keys {
+			if !slices.Contains(fields, key.field) {
+				continue
+			}
+			if score := linkScore(compact, phonetic, key); score > best.Score {
+				best.Score, best.Matched = score, key.text
+			}
+		}
+		if best.Score >= minCandidateScore {
+			candidates = append(candidates, best)
+		}
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].Score > candidates[b].Score })
+	if l.MaxCandidates > 0 && len(candidates) > l.MaxCandidates {
+		candidates = candidates[:l.MaxCandidates]
+	}
+	return candidates
+}
+
+// linkScore scores a value against a product key in [0, 1]
+// Combines edit distance for misspellings, containment for partial names
+// and consonant skeletons for Thai/English transliterations
+func linkScore(compact, phonetic string, key linkKey) float64 {
+	if key.compact == "" {
+		return 0
+	}
+	if compact == key.compact {
+		return 1
+	}
+
+	score := 1 - float64(levenshtein(compact, key.compact))/float64(max(len([]rune(compact)), len([]rune(key.compact))))
+	shorter, longer := compact, key.compact
+	if len(shorter) > len(longer) {
+		shorter, longer = longer, shorter
+	}
+	if len([]rune(shorter)) >= minStemLength && strings.Contains(longer, shorter) {
+		// "iphone" against "iphone15pro": related, but weaker the more the key adds
+		score = max(score, 0.6+0.3*float64(len([]rune(shorter)))/float64(len([]rune(longer))))
+	}
+
+	if len(phonetic) >= minPhoneticKeyLength && len(key.phonetic) >= minPhoneticKeyLength {
+		phoneticScore := 1 - float64(levenshtein(phonetic, key.phonetic))/float64(max(len(phonetic), len(key.phonetic)))
+		score = max(score, phoneticScore*phoneticDiscount)
+	} else if len(phonetic) > 1 && phonetic == key.phonetic {
+		score = max(score, shortPhoneticScore)
+	}
+	return max(score, 0)
+}
+
+// linkEntities attaches link candidates to product, brand and model entities
+// Entities whose best candidate reaches the threshold get linked_product_id and link_score
+func (s *parseSession) linkEntities() {
+	linker := s.processor.linker
+	if linker == nil {
+		return
+	}
+
+	counts := map[string]int{"linked": 0, "unlinked": 0}
+	for i := range s.response.Entities {
+		entity := &s.response.Entities[i]
+		if _, ok := linker.EntityFields[entity.Type]; !ok {
+			continue
+		}
+		if entity.Metadata == nil {
+			entity.Metadata = make(map[string]any)
+		}
+		candidates := linker.Link(entity.Type, entity.Value)
+		entity.Metadata["link_candidates"] = candidates
+		if len(candidates) == 0 || candidates[0].Score < linker.Threshold {
+			counts["unlinked"]++
+			continue
+		}
+		entity.Metadata["linked_product_id"] = candidates[0].ProductID
+		entity.Metadata["link_score"] = candidates[0].Score
+		counts["linked"]++
+	}
+	s.response.ParsingMetadata["entity_linking"] = counts
+}
+
+// compactText lowercases text and keeps only letters, digits and Thai vowel signs
+func compactText(text string) string {
+	text = strings.ToLower(replaceThaiDigits(text))
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) {
+			return r
+		}
+		return -1
+	}, text)
+}
+
+// Latin spellings folded before building consonant skeletons
+var latinPhoneticReplacer = strings.NewReplacer(
+	"ph", "f", "th", "t", "sh", "s", "ch", "c", "ck", "k", "kh", "k", "ng", "g",
+	"c", "k", "q", "k", "x", "ks", "z", "s", "v", "w", "j", "c",
+)
+
+// thaiConsonantSounds maps Thai consonants to the Latin letter used in skeletons
+// อ is a vowel carrier; ย, ญ, ห and ฮ are dropped like Latin y and h
+var thaiConsonantSounds = map[rune]string{
+	'ก': "k", 'ข': "k", 'ค': "k", 'ฆ': "k", 'ง': "g",
+	'จ': "c", 'ฉ': "c", 'ช': "c", 'ฌ': "c", 'ซ': "s", 'ศ': "s", 'ษ': "s", 'ส': "s",
+	'ญ': "", 'ย': "",
+	'ฎ': "d", 'ด': "d", 'ฏ': "t", 'ต': "t", 'ฐ': "t", 'ฑ': "t", 'ฒ': "t", 'ถ': "t", 'ท': "t", 'ธ': "t",
+	'ณ': "n", 'น': "n",
+	'บ': "b", 'ป': "p", 'ผ': "p", 'พ': "p", 'ภ': "p", 'ฝ': "f", 'ฟ': "f",
+	'ม': "m", 'ร': "r", 'ล': "l", 'ฬ': "l", 'ว': "w",
+	'ห': "", 'ฮ': "", 'อ': "",
+}
+
+// thaiThanthakhat silences the consonant before it, as in "ไวร์เลส"
+const thaiThanthakhat = '์'
+
+// phoneticKey reduces text to a consonant skeleton so that transliterations
+// like "ไอโฟน" and "iphone" or "ซัมซุง" and "samsung" share a key
+// Vowels, tone marks and repeated letters are dropped; digits are kept
+func phoneticKey(text string) string {
+	latin := latinPhoneticReplacer.Replace(strings.ToLower(replaceThaiDigits(text)))
+	runes := []rune(latin)
+
+	var b strings.Builder
+	last := ""
+	for i, r := range runes {
+		var sound string
+		switch {
+		case i+1 < len(runes) && runes[i+1] == thaiThanthakhat:
+			continue
+		case r >= 'a' && r <= 'z':
+			if strings.ContainsRune("aeiouyh", r) {
+				continue
+			}
+			sound = string(r)
+		case unicode.IsDigit(r):
+			sound = string(r)
+		default:
+			sound = thaiConsonantSounds[r]
+		}
+		if sound == "" || (sound == last && !unicode.IsDigit(r)) {
+			continue
+		}
+		b.WriteString(sound)
+		last = sound
+	}
+	return b.String()
+}
@@ -0,0 +1,321 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/model"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultCurrency is assumed for price and budget values without a currency marker
+const DefaultCurrency = "THB"
+
+// Entity types converted into model.NormalizedValue
+var (
+	moneyEntityTypes    = []string{"price", "budget"}
+	quantityEntityTypes = []string{"quantity"}
+)
+
+// Qualifiers of normalized values
+const (
+	QualifierApprox = "approx"
+	QualifierMin    = "min"
+	QualifierMax    = "max"
+)
+
+// currencyMarkers maps currency symbols and words to ISO 4217 codes, longest first
+var currencyMarkers = []struct{ marker, code string }{
+	{"ดอลลาร์", "USD"}, {"dollars", "USD"}, {"dollar", "USD"}, {"usd", "USD"}, {"$", "USD"},
+	{"baht", "THB"}, {"บาท", "THB"}, {"thb", "THB"}, {"฿", "THB"},
+	{"ยูโร", "EUR"}, {"euro", "EUR"}, {"eur", "EUR"}, {"€", "EUR"},
+	{"เยน", "JPY"}, {"yen", "JPY"}, {"jpy", "JPY"}, {"¥", "JPY"},
+}
+
+// unitMarkers maps counting words to canonical units, longest first
+var unitMarkers = []struct{ marker, unit string }{
+	{"เครื่อง", "device"}, {"กล่อง", "box"}, {"ชิ้น", "piece"}, {"แพ็ค", "pack"}, {"แพค", "pack"},
+	{"ขวด", "bottle"}, {"ชุด", "set"}, {"คู่", "pair"}, {"อัน", "piece"}, {"ตัว", "piece"}, {"ใบ", "piece"},
+	{"pieces", "piece"}, {"bottles", "bottle"}, {"piece", "piece"}, {"bottle", "bottle"},
+	{"boxes", "box"}, {"pairs", "pair"}, {"packs", "pack"}, {"items", "piece"}, {"units", "piece"},
+	{"pair", "pair"}, {"pack", "pack"}, {"item", "piece"}, {"unit", "piece"}, {"sets", "set"},
+	{"pcs", "piece"}, {"box", "box"}, {"set", "set"}, {"pc", "piece"},
+}
+
+// unitPatterns holds the compiled matcher of each unitMarkers entry
+var unitPatterns = func() []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, len(unitMarkers))
+	for i, m := range unitMarkers {
+		patterns[i] = wordPattern(m.marker)
+	}
+	return patterns
+}()
+
+// qualifierMarkers maps estimate and bound words to qualifiers, longest first
+var qualifierMarkers = []struct{ marker, qualifier string }{
+	{"approximately", QualifierApprox}, {"ประมาณ", QualifierApprox}, {"around", QualifierApprox},
+	{"approx", QualifierApprox}, {"about", QualifierApprox}, {"ราวๆ", QualifierApprox}, {"ราว", QualifierApprox},
+	{"ไม่เกิน", QualifierMax}, {"ไม่ถึง", QualifierMax}, {"ต่ำกว่า", QualifierMax}, {"less than", QualifierMax},
+	{"at most", QualifierMax}, {"up to", QualifierMax}, {"under", QualifierMax}, {"below", QualifierMax},
+	{"ขั้นต่ำ", QualifierMin}, {"ตั้งแต่", QualifierMin}, {"อย่างน้อย", QualifierMin}, {"มากกว่า", QualifierMin},
+	{"at least", QualifierMin}, {"more than", QualifierMin}, {"over", QualifierMin},
+}
+
+// rangeSeparator splits "500-1,000", "2k ~ 3k", "สองถึงสามพัน" and "2 to 3"
+var rangeSeparator = regexp.MustCompile(`\s*(?:-|–|~|ถึง|\bto\b)\s*`)
+
+// Thai number words
+var (
+	thaiDigitWords = []struct {
+		word  string
+		value float64
+	}{
+		{"ศูนย์", 0}, {"หนึ่ง", 1}, {"เอ็ด", 1}, {"นึง", 1}, {"สอง", 2}, {"ยี่", 2}, {"สาม", 3},
+		{"สี่", 4}, {"ห้า", 5}, {"หก", 6}, {"เจ็ด", 7}, {"แปด", 8}, {"เก้า", 9},
+	}
+	thaiMultiplierWords = []struct {
+		word  string
+		value float64
+	}{
+		{"สิบ", 10}, {"ร้อย", 100}, {"พัน", 1000}, {"หมื่น", 10000}, {"แสน", 100000}, {"ล้าน", 1000000},
+	}
+)
+
+// NormalizeEntityValue converts the value of a price, budget or quantity entity into a typed value:
+// "฿1,990", "1,990.-", "2.5k baht", "500-1,000 บาท", "ไม่เกินสองพัน", "~3 ชิ้น"
+// Returns false for other entity types and for values that contain no recognisable number
+func NormalizeEntityValue(entityType, value, defaultCurrency string) (*model.NormalizedValue, bool) {
+	isMoney := slices.Contains(moneyEntityTypes, entityType)
+	if !isMoney && !slices.Contains(quantityEntityTypes, entityType) {
+		return nil, false
+	}
+
+	text := strings.ToLower(strings.TrimSpace(replaceThaiDigits(value)))
+	normalized := &model.NormalizedValue{}
+
+	if isMoney {
+		for _, m := range currencyMarkers {
+			if strings.Contains(text, m.marker) {
+				if normalized.Currency == "" {
+					normalized.Currency = m.code
+				}
+				text = strings.ReplaceAll(text, m.marker, " ")
+			}
+		}
+		if normalized.Currency == "" {
+			normalized.Currency = defaultCurrency
+		}
+	} else {
+		for i, m := range unitMarkers {
+			if unitPatterns[i].MatchString(text) {
+				if normalized.Unit == "" {
+					normalized.Unit = m.unit
+				}
+				text = unitPatterns[i].ReplaceAllString(text, " ")
+			}
+		}
+	}
+
+	for _, m := range qualifierMarkers {
+		if strings.Contains(text, m.marker) {
+			if normalized.Qualifier == "" {
+				normalized.Qualifier = m.qualifier
+			}
+			text = strings.ReplaceAll(text, m.marker, " ")
+		}
+	}
+
+	text = strings.TrimSpace(text)
+	// A leading "~" is an estimate, not a range
+	if strings.HasPrefix(text, "~") {
+		text = strings.TrimSpace(strings.TrimPrefix(text, "~"))
+		if normalized.Qualifier == "" {
+			normalized.Qualifier = QualifierApprox
+		}
+	}
+
+	// "1,990.-" is the Thai notation of a whole price, the dash does not open a range
+	if trimmed, ok := strings.CutSuffix(text, "-"); ok {
+		text = strings.TrimSpace(strings.TrimSuffix(trimmed, "."))
+	}
+
+	parts := rangeSeparator.Split(text, -1)
+	switch len(parts) {
+	case 1:
+		amount, _, ok := parseNumber(parts[0])
+		if !ok {
+			return nil, false
+		}
+		normalized.Amount = amount
+	case 2:
+		low, lowScale, okLow := parseNumber(parts[0])
+		high, highScale, okHigh := parseNumber(parts[1])
+		if !okLow || !okHigh {
+			return nil, false
+		}
+		// "2-3 พัน" applies the multiplier of the upper bound to both bounds
+		if lowScale == 1 && highScale > 1 && low*highScale <= high {
+			low *= highScale
+		}
+		if low > high {
+			low, high = high, low
+		}
+		normalized.Amount = low
+		normalized.MaxAmount = high
+	default:
+		return nil, false
+	}
+	return normalized, true
+}
+
+// parseNumber parses numerals, Thai number words and multiplier suffixes:
+// "1,990", "2.5k", "1.2m", "3 หมื่น", "สองพันห้า", "หมื่นห้า", "1.5 ล้าน"
+// Returns the value and the largest multiplier used (1 when there is none)
+func parseNumber(s string) (float64, float64, bool) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if s == "" {
+		return 0, 0, false
+	}
+
+	var total, section, digit float64
+	lastMultiplier, scale := 0.0, 1.0
+	hasDigit, trailingDigitWord := false, ""
+
+	for s != "" {
+		r := rune(s[0])
+		switch {
+		case r == ' ':
+			s = s[1:]
+			continue
+		case unicode.IsDigit(r) || r == '.':
+			end := 0
+			for end < len(s) && (unicode.IsDigit(rune(s[end])) || s[end] == '.') {
+				end++
+			}
+			value, err := strconv.ParseFloat(s[:end], 64)
+			if err != nil {
+				return 0, 0, false
+			}
+			digit, hasDigit, trailingDigitWord = value, true, ""
+			s = s[end:]
+			continue
+		case r == 'k':
+			section += multiplyDigit(digit, hasDigit) * 1000
+			digit, hasDigit, trailingDigitWord, lastMultiplier = 0, false, "", 1000
+			scale = max(scale, 1000)
+			s = s[1:]
+			continue
+		case r == 'm':
+			total = (total + section + multiplyDigit(digit, hasDigit || section > 0)) * 1000000
+			section, digit, hasDigit, trailingDigitWord, lastMultiplier = 0, 0, false, "", 1000000
+			scale = max(scale, 1000000)
+			s = s[1:]
+			continue
+		}
+
+		matched := false
+		for _, w := range thaiDigitWords {
+			if strings.HasPrefix(s, w.word) {
+				digit, hasDigit, trailingDigitWord = w.value, true, w.word
+				s = s[len(w.word):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		for _, w := range thaiMultiplierWords {
+			if strings.HasPrefix(s, w.word) {
+				if w.value == 1000000 {
+					total = (total + section + multiplyDigit(digit, hasDigit || section > 0)) * w.value
+					section = 0
+				} else {
+					section += multiplyDigit(digit, hasDigit) * w.value
+				}
+				digit, hasDigit, trailingDigitWord, lastMultiplier = 0, false, "", w.value
+				scale = max(scale, w.value)
+				s = s[len(w.word):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, 0, false
+		}
+	}
+
+	if hasDigit && trailingDigitWord != "" && lastMultiplier >= 100 {
+		switch trailingDigitWord {
+		case "นึง":
+			// "พันนึง" is one thousand, the multiplier already counted it
+			digit = 0
+		case "เอ็ด":
+			// "ร้อยเอ็ด" is 101, เอ็ด always stands for units
+		default:
+			// Colloquial "สองพันห้า" (2,500): a bare digit after a multiplier is one order lower
+			digit *= lastMultiplier / 10
+		}
+	}
+
+	if !hasDigit && lastMultiplier == 0 {
+		return 0, 0, false
+	}
+	return total + section + digit, scale, true
+}
+
+// multiplyDigit returns the digit in front of a multiplier, 1 when it was omitted ("พัน" = 1,000)
+func multiplyDigit(digit float64, hasDigit bool) float64 {
+	if !hasDigit {
+		return 1
+	}
+	return digit
+}
+
+// replaceThaiDigits converts Thai digits (๐-๙) to ASCII digits
+func replaceThaiDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '๐' && r <= '๙' {
+			return '0' + (r - '๐')
+		}
+		return r
+	}, s)
+}
+
+// wordPattern matches marker, bounded by non-letters when it is a Latin word
+func wordPattern(marker string) *regexp.Regexp {
+	if marker[0] < 'a' || marker[0] > 'z' {
+		return regexp.MustCompile(regexp.QuoteMeta(marker))
+	}
+	return regexp.MustCompile(`(?:^|[^a-z])` + regexp.QuoteMeta(marker) + `(?:$|[^a-z])`)
+}
+
+// normalizeEntities fills Entity.Normalized for price, budget and quantity entities
+// Counts are recorded in ParsingMetadata["normalization"]
+func (s *parseSession) normalizeEntities() {
+	if !s.processor.config.NormalizeEntities {
+		return
+	}
+
+	counts := map[string]int{"normalized": 0, "failed": 0}
+	for i := range s.response.Entities {
+		entity := &s.response.Entities[i]
+		if !slices.Contains(moneyEntityTypes, entity.Type) && !slices.Contains(quantityEntityTypes, entity.Type) {
+			continue
+		}
+		normalized, ok := NormalizeEntityValue(entity.Type, entity.Value, s.processor.config.DefaultCurrency)
+		if !ok {
+			counts["failed"]++
+			if entity.Metadata == nil {
+				entity.Metadata = make(map[string]any)
+			}
+			entity.Metadata["normalization_error"] = fmt.Sprintf("no number found in %q", entity.Value)
+			continue
+		}
+		entity.Normalized = normalized
+		counts["normalized"]++
+	}
+	s.response.ParsingMetadata["normalization"] = counts
+}
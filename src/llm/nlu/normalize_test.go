@@ -0,0 +1,33 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/model"
+	"testing"
+)
+
+func TestNormalizeEntityValue(t *testing.T) {
+	tests := []struct {
+		entityType string
+		value      string
+		want       model.NormalizedValue
+	}{
+		{"price", "฿1,990", model.NormalizedValue{Amount: 1990, Currency: "THB"}},
+		{"price", "1,990.-", model.NormalizedValue{Amount: 1990, Currency: "THB"}},
+		{"price", "1,990.- บาท", model.NormalizedValue{Amount: 1990, Currency: "THB"}},
+		{"price", "$25-", model.NormalizedValue{Amount: 25, Currency: "USD"}},
+		{"budget", "2.5k baht", model.NormalizedValue{Amount: 2500, Currency: "THB"}},
+		{"price", "500-1,000 บาท", model.NormalizedValue{Amount: 500, MaxAmount: 1000, Currency: "THB"}},
+		{"budget", "ไม่เกินสองพัน", model.NormalizedValue{Amount: 2000, Currency: "THB", Qualifier: QualifierMax}},
+		{"quantity", "~3 ชิ้น", model.NormalizedValue{Amount: 3, Unit: "piece", Qualifier: QualifierApprox}},
+	}
+	for _, tt := range tests {
+		got, ok := NormalizeEntityValue(tt.entityType, tt.value, DefaultCurrency)
+		if !ok {
+			t.Errorf("NormalizeEntityValue(%q, %q) failed", tt.entityType, tt.value)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("NormalizeEntityValue(%q, %q) = %+v, want %+v", tt.entityType, tt.value, *got, tt.want)
+		}
+	}
+}
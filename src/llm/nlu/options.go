@@ -0,0 +1,111 @@
+package nlu
+
+// ParseMode controls how NLUProcessor reacts to tuples it cannot parse
+type ParseMode string
+
+const (
+	// ParseModeLenient logs and skips bad tuples, always returning the partial response
+	ParseModeLenient ParseMode = "lenient"
+	// ParseModeStrict returns a *ParseError when any tuple fails or the completion delimiter is missing
+	ParseModeStrict ParseMode = "strict"
+)
+
+// ProcessorOption configures an NLUProcessor
+type ProcessorOption func(*NLUProcessor)
+
+// WithParseMode sets the strict/lenient parsing behaviour
+func WithParseMode(mode ParseMode) ProcessorOption {
+	return func(n *NLUProcessor) {
+		if mode == ParseModeStrict || mode == ParseModeLenient {
+			n.config.Mode = mode
+		}
+	}
+}
+
+// WithCatalog validates parsed intents and entities against the allowed labels
+// Unknown labels are remapped to the closest catalog entry or rejected
+func WithCatalog(catalog *Catalog) ProcessorOption {
+	return func(n *NLUProcessor) {
+		n.catalog = catalog
+	}
+}
+
+// WithFormatRecovery enables or disables normalization of drifted tuple formats
+// (code fences, real tabs, newline-separated records, missing parentheses, trailing prose)
+func WithFormatRecovery(enabled bool) ProcessorOption {
+	return func(n *NLUProcessor) {
+		n.config.FormatRecovery = enabled
+	}
+}
+
+// WithOutputMode selects how ParseResponse decodes model output
+func WithOutputMode(mode OutputMode) ProcessorOption {
+	return func(n *NLUProcessor) {
+		n.config.OutputMode = mode
+	}
+}
+
+// WithMaxContinuations sets how many continuation requests ContinueTruncated may make
+func WithMaxContinuations(maxContinuations int) ProcessorOption {
+	return func(n *NLUProcessor) {
+		if maxContinuations >= 0 {
+			n.config.MaxContinuations = maxContinuations
+		}
+	}
+}
+
+// WithEntityNormalization enables typed normalization of price, budget and quantity entities
+// defaultCurrency is used for money values without a currency marker, empty keeps the current one
+func WithEntityNormalization(enabled bool, defaultCurrency string) ProcessorOption {
+	return func(n *NLUProcessor) {
+		n.config.NormalizeEntities = enabled
+		if defaultCurrency != "" {
+			n.config.DefaultCurrency = defaultCurrency
+		}
+	}
+}
+
+// WithEntityLinker links product, brand and model entities to a product catalog, nil disables linking
+func WithEntityLinker(linker *EntityLinker) ProcessorOption {
+	return func(n *NLUProcessor) {
+		n.linker = linker
+	}
+}
+
+// WithPromptVersion records the system prompt version in ParsingMetadata["prompt_version"]
+func WithPromptVersion(version string) ProcessorOption {
+	return func(n *NLUProcessor) {
+		if version != "" {
+			n.config.PromptVersion = version
+		}
+	}
+}
+
+// WithTupleRegistry sets the tuple types the processor can parse
+// Use it to add custom dimensions registered with TupleRegistry.Register
+func WithTupleRegistry(registry *TupleRegistry) ProcessorOption {
+	return func(n *NLUProcessor) {
+		if registry != nil {
+			n.registry = registry
+		}
+	}
+}
+
+// WithCalibration applies the calibrators fitted for modelName to intent and entity confidences
+// ParseMessage uses the calibrators of the model that answered instead when the message names it;
+// responses of a model calibrations has no entry for are left uncalibrated
+func WithCalibration(calibrations *Calibrations, modelName string) ProcessorOption {
+	return func(n *NLUProcessor) {
+		n.calibrations = calibrations
+		n.calibration = calibrations.ForModel(modelName)
+	}
+}
+
+// WithImportanceScorer replaces the scoring policy used for ImportanceScore
+func WithImportanceScorer(scorer ImportanceScorer) ProcessorOption {
+	return func(n *NLUProcessor) {
+		if scorer != nil {
+			n.scorer = scorer
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"eino_llm_poc/src/model"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -39,7 +40,13 @@ type TupleParser interface {
 
 // NLUProcessor handles parsing configuration
 type NLUProcessor struct {
-	config *ProcessorConfig
+	config       *ProcessorConfig
+	catalog      *Catalog
+	registry     *TupleRegistry
+	calibrations *Calibrations
+	calibration  *ModelCalibration // calibrators of the configured model
+	scorer       ImportanceScorer
+	linker       *EntityLinker
 }
 
 // ProcessorConfig contains parsing configuration
@@ -47,6 +54,13 @@ type ProcessorConfig struct {
 	RecordDelimiter     string
 	TupleDelimiter      string
 	CompletionDelimiter string
+	Mode                ParseMode
+	FormatRecovery      bool
+	OutputMode          OutputMode
+	MaxContinuations    int
+	NormalizeEntities   bool
+	DefaultCurrency     string
+	PromptVersion       string
 }
 
 // Specific parser implementations
@@ -66,15 +80,44 @@ type SentimentParser struct {
 	*model.Sentiment
 }
 
+type AspectSentimentParser struct {
+	*model.AspectSentiment
+}
+
 // NewNLUProcessor creates a new NLU processor with default configuration
-func NewNLUProcessor() *NLUProcessor {
-	return &NLUProcessor{
+// Options override the defaults, e.g. WithParseMode(ParseModeStrict)
+func NewNLUProcessor(opts ...ProcessorOption) *NLUProcessor {
+	n := &NLUProcessor{
 		config: &ProcessorConfig{
 			RecordDelimiter:     DefaultRecordDelimiter,
 			TupleDelimiter:      DefaultTupleDelimiter,
 			CompletionDelimiter: DefaultCompletionDelimiter,
+			Mode:                ParseModeLenient,
+			FormatRecovery:      true,
+			OutputMode:          OutputModeTuple,
+			MaxContinuations:    DefaultMaxContinuations,
+			NormalizeEntities:   true,
+			DefaultCurrency:     DefaultCurrency,
+			PromptVersion:       DefaultPromptVersion,
 		},
+		registry: NewTupleRegistry(),
+		scorer:   NewWeightedScorer(DefaultImportanceWeights()),
 	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// ForCatalog returns a processor sharing this one's configuration that validates against catalog,
+// used for requests with their own catalog
+func (n *NLUProcessor) ForCatalog(catalog *Catalog) *NLUProcessor {
+	if catalog == n.catalog {
+		return n
+	}
+	clone := *n
+	clone.catalog = catalog
+	return &clone
 }
 
 // Validation utility functions
@@ -189,13 +232,7 @@ func (p *EntityParser) Parse(raw *RawTuple) error {
 	}
 
 	// Extract position from metadata if available
-	if pos, ok := p.Entity.Metadata["entity_position"].([]interface{}); ok && len(pos) == 2 {
-		if start, ok1 := pos[0].(float64); ok1 {
-			if end, ok2 := pos[1].(float64); ok2 {
-				p.Entity.Position = []int{int(start), int(end)}
-			}
-		}
-	}
+	p.Entity.Position = positionFromMetadata(p.Entity.Metadata)
 
 	return nil
 }
@@ -231,6 +268,21 @@ func (p *LanguageParser) Parse(raw *RawTuple) error {
 		p.Language.Metadata = make(map[string]any)
 	}
 
+	// Canonicalize to ISO 639-3, keeping what the model wrote when it differs
+	// Well-formed codes newer than the registry are kept and flagged instead of failing the tuple
+	code, err := CanonicalLanguageCode(p.Language.Code)
+	if err != nil {
+		code = strings.ToLower(strings.TrimSpace(p.Language.Code))
+		if !isLanguageCodeShape(code) {
+			return err
+		}
+		p.Language.Metadata["unknown_code"] = true
+	}
+	if code != p.Language.Code {
+		p.Language.Metadata["original_code"] = p.Language.Code
+		p.Language.Code = code
+	}
+
 	return nil
 }
 
@@ -268,21 +320,58 @@ func (p *SentimentParser) AddToResponse(response *model.NLUResponse) {
 	response.Sentiment = *p.Sentiment
 }
 
-// Factory function to create appropriate parser based on tuple type
-// Returns the specific parser implementation for the given tuple type
-func createParser(tupleType string) (TupleParser, error) {
-	switch tupleType {
-	case "intent":
-		return &IntentParser{Intent: &model.Intent{}}, nil
-	case "entity":
-		return &EntityParser{Entity: &model.Entity{}}, nil
-	case "language":
-		return &LanguageParser{Language: &model.Language{}}, nil
-	case "sentiment":
-		return &SentimentParser{Sentiment: &model.Sentiment{}}, nil
-	default:
-		return nil, fmt.Errorf("unknown tuple type: %s", tupleType)
+func (p *AspectSentimentParser) Parse(raw *RawTuple) error {
+	if len(raw.Parts) < 5 {
+		return fmt.Errorf("aspect_sentiment tuple requires at least 5 parts, got %d", len(raw.Parts))
+	}
+
+	var err error
+	p.AspectSentiment.Aspect = strings.TrimSpace(raw.Parts[1])
+	if err = validateString(p.AspectSentiment.Aspect, 100, "aspect"); err != nil {
+		return err
+	}
+
+	p.AspectSentiment.Span = strings.TrimSpace(raw.Parts[2])
+	if err = validateString(p.AspectSentiment.Span, 500, "aspect span"); err != nil {
+		return err
+	}
+
+	p.AspectSentiment.Label = strings.TrimSpace(raw.Parts[3])
+	if err = validateString(p.AspectSentiment.Label, 50, "aspect sentiment label"); err != nil {
+		return err
+	}
+
+	if p.AspectSentiment.Confidence, err = parseFloat(raw.Parts[4], "confidence"); err != nil {
+		return err
+	}
+
+	if len(raw.Parts) >= 6 {
+		if p.AspectSentiment.Metadata, err = parseMetadataJSON(raw.Parts[5]); err != nil {
+			return err
+		}
+	} else {
+		p.AspectSentiment.Metadata = make(map[string]any)
+	}
+
+	p.AspectSentiment.Position = positionFromMetadata(p.AspectSentiment.Metadata)
+
+	return nil
+}
+
+func (p *AspectSentimentParser) AddToResponse(response *model.NLUResponse) {
+	response.AspectSentiments = append(response.AspectSentiments, *p.AspectSentiment)
+}
+
+// positionFromMetadata reads a [start, end) span from metadata["entity_position"], nil when absent
+func positionFromMetadata(metadata map[string]any) []int {
+	if pos, ok := metadata["entity_position"].([]interface{}); ok && len(pos) == 2 {
+		if start, ok1 := pos[0].(float64); ok1 {
+			if end, ok2 := pos[1].(float64); ok2 {
+				return []int{int(start), int(end)}
+			}
+		}
 	}
+	return nil
 }
 
 // parseRawTuple converts a tuple string into a structured RawTuple
@@ -312,68 +401,208 @@ func (n *NLUProcessor) parseRawTuple(tupleStr string) (*RawTuple, error) {
 	}, nil
 }
 
-// ParseResponse parses the complete model response into structured NLUResponse
-func (n *NLUProcessor) ParseResponse(content string) (*model.NLUResponse, error) {
-	response := &model.NLUResponse{
-		Intents:         []model.Intent{},
-		Entities:        []model.Entity{},
-		Languages:       []model.Language{},
-		ImportanceScore: 0.0,
-		PrimaryIntent:   "",
-		PrimaryLanguage: "",
-		Metadata:        make(map[string]any),
-		ParsingMetadata: map[string]any{"status": "success"},
-		Timestamp:       time.Now(),
+// parseSession accumulates the records of a single model response
+// Shared by ParseResponse and StreamParser so both paths produce identical results
+type parseSession struct {
+	processor      *NLUProcessor
+	response       *model.NLUResponse
+	completionSeen bool
+	parsedCount    int
+	skippedCount   int
+	failures       []TupleFailure
+	corrections    []CatalogCorrection
+	fixes          []string
+}
+
+// newParseSession creates an empty parsing session bound to the processor configuration
+func (n *NLUProcessor) newParseSession() *parseSession {
+	return &parseSession{
+		processor: n,
+		response: &model.NLUResponse{
+			Intents:          []model.Intent{},
+			Entities:         []model.Entity{},
+			Languages:        []model.Language{},
+			AspectSentiments: []model.AspectSentiment{},
+			ImportanceScore:  0.0,
+			PrimaryIntent:    "",
+			PrimaryLanguage:  "",
+			Metadata:         make(map[string]any),
+			ParsingMetadata:  map[string]any{"prompt_version": n.config.PromptVersion},
+			Timestamp:        time.Now(),
+		},
 	}
+}
 
-	// Split by record delimiter
-	records := strings.Split(content, n.config.RecordDelimiter)
+// addRecord parses a single record and adds it to the session response
+// Returns a nil parser when the record was skipped (empty, completion marker or not a tuple)
+func (s *parseSession) addRecord(record string) (TupleParser, error) {
+	trimmedRecord, fixes, drop := s.processor.normalizeRecord(strings.TrimSpace(record))
+	s.addFixes(fixes...)
+	if drop {
+		return nil, nil
+	}
 
-	for _, record := range records {
-		trimmedRecord := strings.TrimSpace(record)
-		if n.shouldSkipRecord(trimmedRecord) {
-			continue
+	if s.processor.isCompletionRecord(trimmedRecord) {
+		s.completionSeen = true
+		return nil, nil
+	}
+	if s.processor.shouldSkipRecord(trimmedRecord) {
+		return nil, nil
+	}
+
+	// Anything after the completion delimiter is commentary appended by the model
+	if s.completionSeen && s.processor.config.FormatRecovery {
+		s.addFixes(FixTrailingText)
+		return nil, nil
+	}
+
+	// Text without any tuple delimiter is stray commentary, not a broken tuple
+	if !s.processor.looksLikeTuple(trimmedRecord) {
+		s.skippedCount++
+		logger.Debug().Str("record", trimmedRecord).Msg("Skipping non-tuple record")
+		return nil, nil
+	}
+
+	parser, err := s.parseRecord(trimmedRecord)
+	return s.track(trimmedRecord, parser, err)
+}
+
+// track updates the session counters with the outcome of parsing a single record
+func (s *parseSession) track(source string, parser TupleParser, err error) (TupleParser, error) {
+	if err != nil {
+		s.failures = append(s.failures, TupleFailure{Tuple: source, Reason: err.Error()})
+		logger.Warn().Err(err).Str("tuple", source).Msg("Failed to parse tuple")
+		return nil, err
+	}
+
+	s.parsedCount++
+	return parser, nil
+}
+
+// addFixes records applied format fixes once each, keeping first-seen order
+func (s *parseSession) addFixes(fixes ...string) {
+	for _, fix := range fixes {
+		if !slices.Contains(s.fixes, fix) {
+			s.fixes = append(s.fixes, fix)
 		}
+	}
+}
 
-		if err := n.parseRecord(trimmedRecord, response); err != nil {
-			logger.Warn().Err(err).Str("tuple", trimmedRecord).Msg("Failed to parse tuple")
-			continue
+// finalize calculates derived fields and records parsing diagnostics
+// In strict mode a *ParseError is returned alongside the partial response
+func (s *parseSession) finalize() (*model.NLUResponse, error) {
+	s.normalizeEntities()
+	s.linkEntities()
+	// Calibrate before deriving fields so PrimaryIntent and ImportanceScore use calibrated confidences
+	s.applyCalibration()
+	s.processor.calculateDerivedFields(s.response)
+
+	status := "success"
+	switch {
+	case s.parsedCount == 0:
+		status = "failed"
+	case len(s.failures) > 0 || !s.completionSeen:
+		status = "partial"
+	}
+
+	failures := make([]TupleFailure, len(s.failures))
+	copy(failures, s.failures)
+
+	s.response.ParsingMetadata["status"] = status
+	s.response.ParsingMetadata["parse_mode"] = string(s.processor.config.Mode)
+	s.response.ParsingMetadata["parsed_count"] = s.parsedCount
+	s.response.ParsingMetadata["skipped_count"] = s.skippedCount
+	s.response.ParsingMetadata["failed_count"] = len(s.failures)
+	s.response.ParsingMetadata["failures"] = failures
+	s.response.ParsingMetadata["completion_seen"] = s.completionSeen
+	if s.processor.catalog != nil {
+		corrections := make([]CatalogCorrection, len(s.corrections))
+		copy(corrections, s.corrections)
+		s.response.ParsingMetadata["catalog_corrections"] = corrections
+	}
+	if s.processor.config.FormatRecovery {
+		s.response.ParsingMetadata["format_fixes"] = slices.Clone(s.fixes)
+	}
+
+	if s.processor.config.Mode == ParseModeStrict && (len(failures) > 0 || !s.completionSeen) {
+		return s.response, &ParseError{
+			Failures:       failures,
+			CompletionSeen: s.completionSeen,
 		}
 	}
+	return s.response, nil
+}
 
-	// Calculate derived fields after parsing
-	n.calculateDerivedFields(response)
+// ParseResponse parses the complete model response into structured NLUResponse
+// The content is decoded according to the configured output mode (tuple or JSON)
+// In strict mode the partial response is returned together with a *ParseError
+func (n *NLUProcessor) ParseResponse(content string) (*model.NLUResponse, error) {
+	if n.config.OutputMode == OutputModeJSON {
+		return n.ParseJSONResponse(content)
+	}
 
-	return response, nil
+	session := n.newParseSession()
+
+	// Split by record delimiter, tolerating newline-separated records when recovery is enabled
+	records, fixes, complete := n.splitRecords(content)
+	session.addFixes(fixes...)
+
+	for _, record := range records {
+		// Failures are collected by the session and reported on finalize
+		_, _ = session.addRecord(record)
+	}
+	session.completionSeen = session.completionSeen || complete
+
+	// Calculate derived fields after parsing
+	return session.finalize()
 }
 
 // shouldSkipRecord determines if a record should be ignored during parsing
 // Skips empty records and completion delimiter markers
 func (n *NLUProcessor) shouldSkipRecord(record string) bool {
-	return record == "" ||
-		record == n.config.CompletionDelimiter ||
+	return record == "" || n.isCompletionRecord(record)
+}
+
+// isCompletionRecord reports whether the record is the completion delimiter
+func (n *NLUProcessor) isCompletionRecord(record string) bool {
+	return record == n.config.CompletionDelimiter ||
 		record == DefaultCompletionDelimiter
 }
 
+// looksLikeTuple reports whether a record carries tuple content rather than prose
+func (n *NLUProcessor) looksLikeTuple(record string) bool {
+	return strings.Contains(record, n.config.TupleDelimiter) ||
+		(n.config.FormatRecovery && strings.Contains(record, "\t"))
+}
+
 // parseRecord processes a single tuple record and adds it to the response
-// Orchestrates: raw parsing -> type-specific parsing -> response integration
-func (n *NLUProcessor) parseRecord(record string, response *model.NLUResponse) error {
-	rawTuple, err := n.parseRawTuple(record)
+// Orchestrates: raw parsing -> type-specific parsing -> catalog validation -> response integration
+func (s *parseSession) parseRecord(record string) (TupleParser, error) {
+	rawTuple, err := s.processor.parseRawTuple(record)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return s.addTuple(rawTuple)
+}
 
-	parser, err := createParser(rawTuple.Type)
+// addTuple runs type-specific parsing and catalog validation, then adds the record to the response
+// Shared by the tuple and JSON output modes
+func (s *parseSession) addTuple(rawTuple *RawTuple) (TupleParser, error) {
+	parser, err := s.processor.registry.newParser(rawTuple.Type)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := parser.Parse(rawTuple); err != nil {
-		return err
+		return nil, err
 	}
 
-	parser.AddToResponse(response)
-	return nil
+	if err := s.applyCatalog(parser); err != nil {
+		return nil, err
+	}
+
+	parser.AddToResponse(s.response)
+	return parser, nil
 }
 
 // calculateDerivedFields calculates PrimaryIntent, PrimaryLanguage, and ImportanceScore
@@ -416,19 +645,13 @@ func (n *NLUProcessor) calculateDerivedFields(response *model.NLUResponse) {
 		}
 	}
 
-	// Calculate ImportanceScore using primary intent weighted score
-	if len(response.Intents) > 0 {
-		// Find primary intent (highest confidence)
-		primary := response.Intents[0]
-		for _, intent := range response.Intents {
-			if intent.Confidence > primary.Confidence {
-				primary = intent
-			}
-		}
-
-		// Simple weighted formula: 60% confidence + 40% priority
-		response.ImportanceScore = (primary.Confidence * ConfidenceWeight) + (primary.Priority * PriorityWeight)
+	// Calculate ImportanceScore with the configured scoring policy
+	score, breakdown := n.scorer.Score(response)
+	response.ImportanceScore = score
+	if response.ParsingMetadata == nil {
+		response.ParsingMetadata = make(map[string]any)
 	}
+	response.ParsingMetadata["importance_breakdown"] = breakdown
 }
 
 func ParseNLUResponse(content string) (*model.NLUResponse, error) {
@@ -457,7 +680,8 @@ func ParseNLUResponse(content string) (*model.NLUResponse, error) {
 //    Use: Primary language identification for processing
 //
 // 3. ImportanceScore
-//    Formula: ImportanceScore = (Confidence × ConfidenceWeight) + (Priority × PriorityWeight)
-//    Weights: 60% model confidence + 40% business priority
-//    Range: 0.0 to 1.0 (normalized)
+//    Formula: computed by the processor's ImportanceScorer (WithImportanceScorer)
+//    Default: (Confidence × ConfidenceWeight) + (Priority × PriorityWeight) of the primary intent
+//    WeightedScorer adds secondary intents, a negative-sentiment boost and an entity bonus
+//    Range: 0.0 to 1.0 (clamped), components in ParsingMetadata["importance_breakdown"]
 //    Use: Business decision-making and routing priority
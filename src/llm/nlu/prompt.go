@@ -2,117 +2,322 @@ package nlu
 
 import (
 	"eino_llm_poc/src/model"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
 )
 
-func getSystemTemplate() string {
-	return `You are an expert NLU system. Follow the instructions precisely and return structured output ONLY in the specified tuple format.
-
-			<goal>
-			Given a user utterance, detect and extract the user's **intent**, **entities**, **language**, and **sentiment** using ONLY the provided intent/entity lists.
-
-			**STRICT RULES:**
-			1. Extract intents/entities ONLY if they appear in the provided lists (default or additional).
-			2. DO NOT create new intents/entities not in the lists.
-			3. If input doesn't match exactly, choose the closest intent from the lists (mark {"closest_match": true} in metadata).
-			4. Common greetings (สวัสดี, หวัดดี, hello, hi, good morning) MUST be "greet".
-			5. Entities MUST be literally present in the current message text; DO NOT use conversation context.
-
-			**Delimiters:**
-			- {TD} = a single tab character
-			- {RD} = record delimiter (newline between lines is allowed, but use {RD} explicitly)
-			- {CD} = completion delimiter (must appear once at the end)
-
-			**Numbers:**
-			- confidence: 0–1 with 2 decimals (e.g., 0.95)
-			- priority_score: use the provided score as-is
-			</goal>
-
-			<runtime_input>
-			**Fill at runtime:**
-			- default_intent: {default_intent}          
-			- additional_intent: {additional_intent}   
-			- default_entity: {default_entity}          
-			- additional_entity: {additional_entity}    
-			</runtime_input>
-
-			<steps>
-			1. **INTENTS (top 3 max):**
-			- Consider both default_intent and additional_intent with their priority scores.
-			- Break ties by higher priority_score → higher confidence → earlier occurrence in text.
-			- Format (each on its own line):
-				(intent{TD}<intent_name_in_snake_case>{TD}<confidence>{TD}<priority_score>{TD}{{"extracted_from":"default|additional"}})
-
-			2. **ENTITIES (0 or more):**
-			- Extract ONLY literal spans present in the current message (no inference).
-			- Include a line per occurrence (don't deduplicate).
-			- Provide 0-based [start, end) character offsets.
-			- Format:
-				(entity{TD}<entity_type>{TD}<raw_span>{TD}<confidence>{TD}{{"entity_position":[start,end],"entity_category":"<category_optional>"}})
-
-			3. **LANGUAGES (≥1):**
-			- Detect using ISO 639-3 codes (lowercase), primary first (primary_flag=1), others have primary_flag=0.
-			- Format:
-				(language{TD}<iso_639_3_code>{TD}<confidence>{TD}<primary_flag>{TD}{{"script":"<script>","detected_tokens":<int>}})
-
-			4. **SENTIMENT (exactly 1):**
-			- One of: positive | neutral | negative
-			- Format:
-				(sentiment{TD}<label>{TD}<confidence>{TD}{{"polarity":<float>,"subjectivity":<float>}})
-
-			5. **OUTPUT:**
-			- Return all lines separated by {RD}
-			- End with {CD} on its own line.
-			- No extra commentary or formatting outside the tuples.
-			</steps>
-
-			**Example 1:**
-			text: I want to book a flight to Paris next week.
-			default_intent: book_flight:0.90, cancel_flight:0.70
-			additional_intent: greet:0.30, track_flight:0.50
-			default_entity: location, date
-			additional_entity: airline, person
-
-			Output:
-			(intent{TD}book_flight{TD}0.95{TD}0.90{TD}{"extracted_from":"default"}){RD}
-			(intent{TD}track_flight{TD}0.25{TD}0.50{TD}{"extracted_from":"additional"}){RD}
-			(intent{TD}cancel_flight{TD}0.15{TD}0.70{TD}{"extracted_from":"default"}){RD}
-			(entity{TD}location{TD}Paris{TD}0.98{TD}{"entity_position":[27,32]}){RD}
-			(entity{TD}date{TD}next week{TD}0.94{TD}{"entity_position":[33,42]}){RD}
-			(language{TD}eng{TD}1.00{TD}1{TD}{"script":"latin","detected_tokens":9}){RD}
-			(sentiment{TD}neutral{TD}0.80{TD}{"polarity":0.10,"subjectivity":0.30}){RD}
-			{CD}
-
-			**Example 2:**
-			text: อยากซื้อรองเท้า Hello!
-			default_intent: purchase_intent:0.80
-			additional_intent: ask_product:0.60, cancel_order:0.40, greet:0.30
-			default_entity: product
-			additional_entity: brand, color
-
-			Output:
-			(intent{TD}purchase_intent{TD}0.95{TD}0.80{TD}{"extracted_from":"default"}){RD}
-			(intent{TD}ask_product{TD}0.30{TD}0.60{TD}{"extracted_from":"additional"}){RD}
-			(intent{TD}greet{TD}0.90{TD}0.30{TD}{"extracted_from":"additional"}){RD}
-			(entity{TD}product{TD}รองเท้า{TD}0.97{TD}{"entity_position":[5,11]}){RD}
-			(language{TD}tha{TD}0.85{TD}1{TD}{"script":"thai","detected_tokens":2}){RD}
-			(language{TD}eng{TD}0.95{TD}0{TD}{"script":"latin","detected_tokens":1}){RD}
-			(sentiment{TD}positive{TD}0.75{TD}{"polarity":0.60,"subjectivity":0.40}){RD}
-			{CD}
-			</examples>`
-}
-
-// GetSystemTemplateProcessed returns the processed system template with config values replaced
-func GetSystemTemplateProcessed(nluConfig *model.NLUConfig) string {
-	systemText := getSystemTemplate()
-	replacerSystem := strings.NewReplacer(
-		"{TD}", "<||>",
-		"{RD}", "##",
-		"{CD}", "<|COMPLETE|>",
-		"default_intent", nluConfig.DefaultIntent,
-		"additional_intent", nluConfig.AdditionalIntent,
-		"default_entity", nluConfig.DefaultEntity,
-		"additional_entity", nluConfig.AdditionalEntity,
-	)
-	return replacerSystem.Replace(systemText)
+// DefaultPromptVersion is the prompt version used when NLU_PROMPT_VERSION is not set
+const DefaultPromptVersion = "v3"
+
+// promptFiles holds the system prompt templates as prompts/<version>/<output mode>.txt
+//
+//go:embed prompts
+var promptFiles embed.FS
+
+// Placeholder delimiters of the prompt templates, braces in the prompt text are literal JSON
+const (
+	promptLeftDelim  = "[["
+	promptRightDelim = "]]"
+)
+
+// PromptVariables are the values bound to the placeholders of a system prompt template,
+// referenced as [[.Name]]
+type PromptVariables struct {
+	TD               string // tuple delimiter
+	RD               string // record delimiter
+	CD               string // completion delimiter
+	DefaultIntent    string // "name:priority" list
+	AdditionalIntent string // "name:priority" list
+	DefaultEntity    string
+	AdditionalEntity string
+	AdditionalTuples string // prompt steps of custom tuple types
+	CatalogDetails   string // descriptions and examples of the catalog labels
+	Examples         string // few-shot examples retrieved for the query, empty for the template's static examples
+}
+
+// optionalPromptVariables may be bound to empty values
+var optionalPromptVariables = []string{"AdditionalIntent", "AdditionalEntity", "AdditionalTuples", "CatalogDetails", "Examples"}
+
+// values returns the variables by placeholder name
+func (v PromptVariables) values() map[string]string {
+	return map[string]string{
+		"TD":               v.TD,
+		"RD":               v.RD,
+		"CD":               v.CD,
+		"DefaultIntent":    v.DefaultIntent,
+		"AdditionalIntent": v.AdditionalIntent,
+		"DefaultEntity":    v.DefaultEntity,
+		"AdditionalEntity": v.AdditionalEntity,
+		"AdditionalTuples": v.AdditionalTuples,
+		"CatalogDetails":   v.CatalogDetails,
+		"Examples":         v.Examples,
+	}
+}
+
+// PromptVariablesFromCatalog binds the intent and entity lists and details of the catalog,
+// the default delimiters and the prompt steps of the custom tuple types in registry
+func PromptVariablesFromCatalog(catalog *Catalog, registry *TupleRegistry) PromptVariables {
+	vars := PromptVariables{
+		TD:               DefaultTupleDelimiter,
+		RD:               DefaultRecordDelimiter,
+		CD:               DefaultCompletionDelimiter,
+		DefaultIntent:    catalog.IntentList(CatalogSourceDefault),
+		AdditionalIntent: catalog.IntentList(CatalogSourceAdditional),
+		DefaultEntity:    catalog.EntityList(CatalogSourceDefault),
+		AdditionalEntity: catalog.EntityList(CatalogSourceAdditional),
+		CatalogDetails:   catalog.promptDetails(),
+	}
+	if registry != nil {
+		// Custom tuple prompts use the {TD}, {RD} and {CD} placeholders of TupleType.Prompt
+		vars.AdditionalTuples = strings.NewReplacer(
+			placeholderTupleDelimiter, vars.TD,
+			placeholderRecordDelimiter, vars.RD,
+			placeholderCompletionDelimiter, vars.CD,
+		).Replace(registry.promptFragments())
+	}
+	return vars
+}
+
+// SystemPrompt is a parsed system prompt template of one version and output mode
+type SystemPrompt struct {
+	Version   string
+	Mode      OutputMode
+	Variables []string // placeholder names used by the template
+
+	template *template.Template
+}
+
+// newSystemPrompt parses a template and checks that it only uses known variables
+func newSystemPrompt(version string, mode OutputMode, text string) (*SystemPrompt, error) {
+	name := version + "/" + string(mode)
+	tmpl, err := template.New(name).Delims(promptLeftDelim, promptRightDelim).Option("missingkey=error").
+		Funcs(template.FuncMap{"indent": indentLines}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template %s: %v", name, err)
+	}
+
+	known := PromptVariables{}.values()
+	used := make(map[string]bool)
+	collectTemplateFields(tmpl.Tree.Root, used)
+	variables := make([]string, 0, len(used))
+	for variable := range used {
+		if _, ok := known[variable]; !ok {
+			return nil, fmt.Errorf("prompt template %s uses unknown variable %q", name, variable)
+		}
+		variables = append(variables, variable)
+	}
+	sort.Strings(variables)
+	return &SystemPrompt{Version: version, Mode: mode, Variables: variables, template: tmpl}, nil
+}
+
+// indentLines prefixes every non-empty line of text, used by templates as [[indent "\t" .Name]]
+// Multi-line variables are rendered unindented, so the template decides where they sit
+func indentLines(prefix, text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// collectTemplateFields records the [[.Name]] fields referenced below node
+func collectTemplateFields(node parse.Node, used map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectTemplateFields(child, used)
+		}
+	case *parse.ActionNode:
+		collectTemplateFields(n.Pipe, used)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectTemplateFields(cmd, used)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectTemplateFields(arg, used)
+		}
+	case *parse.FieldNode:
+		used[n.Ident[0]] = true
+	case *parse.IfNode:
+		collectTemplateFields(n.Pipe, used)
+		collectTemplateFields(n.List, used)
+		collectTemplateFields(n.ElseList, used)
+	case *parse.RangeNode:
+		collectTemplateFields(n.Pipe, used)
+		collectTemplateFields(n.List, used)
+		collectTemplateFields(n.ElseList, used)
+	case *parse.WithNode:
+		collectTemplateFields(n.Pipe, used)
+		collectTemplateFields(n.List, used)
+		collectTemplateFields(n.ElseList, used)
+	}
+}
+
+// Render executes the template, every variable it uses must be bound
+// Only AdditionalIntent, AdditionalEntity, AdditionalTuples, CatalogDetails and Examples may be empty
+func (p *SystemPrompt) Render(vars PromptVariables) (string, error) {
+	values := vars.values()
+	for _, variable := range p.Variables {
+		if values[variable] == "" && !slices.Contains(optionalPromptVariables, variable) {
+			return "", fmt.Errorf("prompt %s/%s: variable %s is not bound", p.Version, p.Mode, variable)
+		}
+	}
+	var b strings.Builder
+	if err := p.template.Execute(&b, values); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s/%s: %v", p.Version, p.Mode, err)
+	}
+	return b.String(), nil
+}
+
+// PromptRegistry holds the parsed system prompt templates by version and output mode
+// Older versions may only provide a tuple mode template
+type PromptRegistry struct {
+	prompts map[string]map[OutputMode]*SystemPrompt
+}
+
+// NewPromptRegistry loads the templates of fsys laid out as <version>/<output mode>.txt
+// Templates with syntax errors or unknown variables are rejected
+func NewPromptRegistry(fsys fs.FS) (*PromptRegistry, error) {
+	r := &PromptRegistry{prompts: make(map[string]map[OutputMode]*SystemPrompt)}
+	files, err := fs.Glob(fsys, "*/*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prompt templates: %v", err)
+	}
+	for _, file := range files {
+		version := path.Dir(file)
+		mode := OutputMode(strings.TrimSuffix(path.Base(file), ".txt"))
+		if mode != OutputModeTuple && mode != OutputModeJSON && mode != OutputModeTools {
+			return nil, fmt.Errorf("prompt template %s is not named after an output mode", file)
+		}
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt template %s: %v", file, err)
+		}
+		prompt, err := newSystemPrompt(version, mode, strings.TrimSuffix(string(data), "\n"))
+		if err != nil {
+			return nil, err
+		}
+		if r.prompts[version] == nil {
+			r.prompts[version] = make(map[OutputMode]*SystemPrompt)
+		}
+		r.prompts[version][mode] = prompt
+	}
+	return r, nil
+}
+
+// defaultPromptRegistry loads the embedded templates once
+var defaultPromptRegistry = sync.OnceValues(func() (*PromptRegistry, error) {
+	fsys, err := fs.Sub(promptFiles, "prompts")
+	if err != nil {
+		return nil, err
+	}
+	return NewPromptRegistry(fsys)
+})
+
+// DefaultPromptRegistry returns the registry of the prompt templates embedded in the binary
+func DefaultPromptRegistry() (*PromptRegistry, error) {
+	return defaultPromptRegistry()
+}
+
+// Versions returns the available prompt versions in sorted order
+func (r *PromptRegistry) Versions() []string {
+	versions := make([]string, 0, len(r.prompts))
+	for version := range r.prompts {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// Prompt returns the template of a prompt version for the output mode
+// An empty version selects DefaultPromptVersion
+func (r *PromptRegistry) Prompt(version string, mode OutputMode) (*SystemPrompt, error) {
+	if version == "" {
+		version = DefaultPromptVersion
+	}
+	prompts, ok := r.prompts[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown prompt version %q, available: %s", version, strings.Join(r.Versions(), ", "))
+	}
+	prompt, ok := prompts[mode]
+	if !ok {
+		return nil, fmt.Errorf("prompt version %s has no %s output mode template", version, mode)
+	}
+	return prompt, nil
+}
+
+// renderPrompt renders the embedded template of the configured prompt version
+func renderPrompt(nluConfig *model.NLUConfig, mode OutputMode, catalog *Catalog, registry *TupleRegistry, examples string) (string, error) {
+	prompts, err := DefaultPromptRegistry()
+	if err != nil {
+		return "", err
+	}
+	prompt, err := prompts.Prompt(nluConfig.PromptVersion, mode)
+	if err != nil {
+		return "", err
+	}
+	if catalog == nil {
+		if catalog, err = NewCatalogFromConfig(nluConfig); err != nil {
+			return "", err
+		}
+	}
+	vars := PromptVariablesFromCatalog(catalog, registry)
+	vars.Examples = examples
+	return prompt.Render(vars)
+}
+
+// GetSystemTemplateProcessed returns the tuple mode system prompt rendered with the configured catalog
+func GetSystemTemplateProcessed(nluConfig *model.NLUConfig) (string, error) {
+	return GetSystemTemplateWithTuples(nluConfig, nil, nil)
+}
+
+// GetSystemTemplateWithTuples returns the tuple mode system prompt including the
+// prompt steps of the custom tuple types in registry
+// A nil catalog is built from the configuration
+func GetSystemTemplateWithTuples(nluConfig *model.NLUConfig, catalog *Catalog, registry *TupleRegistry) (string, error) {
+	return renderPrompt(nluConfig, OutputModeTuple, catalog, registry, "")
+}
+
+// GetJSONSystemTemplateProcessed returns the JSON output mode system prompt rendered with the catalog
+func GetJSONSystemTemplateProcessed(nluConfig *model.NLUConfig, catalog *Catalog) (string, error) {
+	return renderPrompt(nluConfig, OutputModeJSON, catalog, nil, "")
+}
+
+// GetToolsSystemTemplateProcessed returns the tools output mode system prompt rendered with the catalog
+func GetToolsSystemTemplateProcessed(nluConfig *model.NLUConfig, catalog *Catalog) (string, error) {
+	return renderPrompt(nluConfig, OutputModeTools, catalog, nil, "")
+}
+
+// GetSystemTemplateForMode returns the rendered system prompt matching the output mode
+// Custom tuple types from registry are only described in tuple mode
+func GetSystemTemplateForMode(nluConfig *model.NLUConfig, mode OutputMode, catalog *Catalog, registry *TupleRegistry) (string, error) {
+	return GetSystemTemplateWithExamples(nluConfig, mode, catalog, registry, "")
+}
+
+// GetSystemTemplateWithExamples returns the system prompt of the output mode with retrieved
+// few-shot examples rendered by NLUProcessor.RenderFewShot in place of the static ones
+func GetSystemTemplateWithExamples(nluConfig *model.NLUConfig, mode OutputMode, catalog *Catalog, registry *TupleRegistry, examples string) (string, error) {
+	switch mode {
+	case OutputModeJSON, OutputModeTools:
+		return renderPrompt(nluConfig, mode, catalog, nil, examples)
+	default:
+		return renderPrompt(nluConfig, OutputModeTuple, catalog, registry, examples)
+	}
 }
@@ -0,0 +1,28 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/model"
+	"strings"
+	"testing"
+)
+
+func TestTuplePromptsHaveNoEscapedBraces(t *testing.T) {
+	prompts, err := DefaultPromptRegistry()
+	if err != nil {
+		t.Fatalf("DefaultPromptRegistry: %v", err)
+	}
+	catalog := NewCatalog(
+		[]CatalogIntent{{Name: "purchase_intent", Priority: 0.8, Source: CatalogSourceDefault}},
+		[]CatalogEntity{{Name: "product", Source: CatalogSourceDefault}},
+	)
+	for _, version := range prompts.Versions() {
+		prompt, err := GetSystemTemplateWithTuples(&model.NLUConfig{PromptVersion: version}, catalog, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", version, err)
+		}
+		// The templates use [[ ]] delimiters, doubled braces would reach the model literally
+		if strings.Contains(prompt, "{{") {
+			t.Errorf("%s tuple prompt contains escaped braces", version)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package nlu
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Format fixes recorded in ParsingMetadata["format_fixes"]
+const (
+	FixCodeFence          = "stripped_code_fence"
+	FixInlineCode         = "stripped_inline_code"
+	FixListMarker         = "stripped_list_marker"
+	FixTrailingPunct      = "stripped_trailing_punctuation"
+	FixTabDelimiter       = "tab_delimiter"
+	FixPlaceholder        = "literal_placeholder_delimiter"
+	FixNewlineDelimiter   = "newline_record_delimiter"
+	FixMissingParentheses = "missing_parentheses"
+	FixTrailingText       = "trailing_text_removed"
+)
+
+// Unreplaced prompt placeholders the model sometimes echoes literally
+const (
+	placeholderTupleDelimiter      = "{TD}"
+	placeholderRecordDelimiter     = "{RD}"
+	placeholderCompletionDelimiter = "{CD}"
+)
+
+// listMarkerPattern matches bullets and numbering in front of a tuple, e.g. "- ", "* ", "2. ", "3) "
+var listMarkerPattern = regexp.MustCompile(`^(?:[-*•]|\d+[.)])\s+`)
+
+// recordBoundaries returns the markers that terminate a record
+// With recovery enabled, newlines and the literal {RD} placeholder also separate records
+func (n *NLUProcessor) recordBoundaries() []string {
+	if !n.config.FormatRecovery {
+		return []string{n.config.RecordDelimiter}
+	}
+	return []string{n.config.RecordDelimiter, placeholderRecordDelimiter, "\n"}
+}
+
+// completionMarkers returns the markers that signal the end of the model output
+func (n *NLUProcessor) completionMarkers() []string {
+	if !n.config.FormatRecovery {
+		return []string{n.config.CompletionDelimiter}
+	}
+	return []string{n.config.CompletionDelimiter, placeholderCompletionDelimiter}
+}
+
+// nextMarker returns the earliest position of any marker in s and the matched marker
+func nextMarker(s string, markers []string) (int, string) {
+	bestIdx, bestMarker := -1, ""
+	for _, marker := range markers {
+		if idx := strings.Index(s, marker); idx >= 0 && (bestIdx < 0 || idx < bestIdx) {
+			bestIdx, bestMarker = idx, marker
+		}
+	}
+	return bestIdx, bestMarker
+}
+
+// isTrailingText reports whether text after the completion delimiter holds more than a closing code fence
+func isTrailingText(text string) bool {
+	return strings.Trim(text, " \t\r\n`") != ""
+}
+
+// splitRecords splits the complete model output into records
+// The output ends at the first completion marker, as in StreamParser.Feed, even when it shares a
+// record with a tuple or is followed by prose. Returns the applied content-level fixes and whether
+// a completion marker was found
+func (n *NLUProcessor) splitRecords(content string) ([]string, []string, bool) {
+	var fixes []string
+	idx, marker := nextMarker(content, n.completionMarkers())
+	complete := idx >= 0
+	if complete {
+		if marker == placeholderCompletionDelimiter {
+			fixes = append(fixes, FixPlaceholder)
+		}
+		if isTrailingText(content[idx+len(marker):]) {
+			fixes = append(fixes, FixTrailingText)
+		}
+		content = content[:idx]
+	}
+
+	if !n.config.FormatRecovery {
+		return strings.Split(content, n.config.RecordDelimiter), fixes, complete
+	}
+
+	if strings.Contains(content, placeholderRecordDelimiter) {
+		content = strings.ReplaceAll(content, placeholderRecordDelimiter, n.config.RecordDelimiter)
+		fixes = append(fixes, FixPlaceholder)
+	}
+
+	var records []string
+	for _, segment := range strings.Split(content, n.config.RecordDelimiter) {
+		lines := strings.Split(segment, "\n")
+		tuples := 0
+		for _, line := range lines {
+			if n.looksLikeTuple(line) {
+				tuples++
+			}
+		}
+		// Several tuples between two record delimiters were separated by newlines only
+		if tuples > 1 {
+			fixes = append(fixes, FixNewlineDelimiter)
+		}
+		records = append(records, lines...)
+	}
+	return records, fixes, complete
+}
+
+// normalizeRecord repairs common format drift in a single trimmed record
+// Returns the repaired record, the fixes applied and whether the record should be dropped
+func (n *NLUProcessor) normalizeRecord(record string) (string, []string, bool) {
+	if !n.config.FormatRecovery || record == "" {
+		return record, nil, false
+	}
+
+	var fixes []string
+	if strings.HasPrefix(record, "```") {
+		return "", []string{FixCodeFence}, true
+	}
+	if record == placeholderCompletionDelimiter {
+		return n.config.CompletionDelimiter, []string{FixPlaceholder}, false
+	}
+
+	if len(record) > 1 && strings.HasPrefix(record, "`") && strings.HasSuffix(record, "`") {
+		record = strings.TrimSpace(strings.Trim(record, "`"))
+		fixes = append(fixes, FixInlineCode)
+	}
+
+	if marker := listMarkerPattern.FindString(record); marker != "" {
+		record = record[len(marker):]
+		fixes = append(fixes, FixListMarker)
+	}
+
+	if strings.Contains(record, placeholderTupleDelimiter) {
+		record = strings.ReplaceAll(record, placeholderTupleDelimiter, n.config.TupleDelimiter)
+		fixes = append(fixes, FixPlaceholder)
+	}
+
+	// The prompt describes {TD} as a tab, so some models emit real tabs
+	if !strings.Contains(record, n.config.TupleDelimiter) && strings.Contains(record, "\t") {
+		parts := strings.Split(record, "\t")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		record = strings.Join(parts, n.config.TupleDelimiter)
+		fixes = append(fixes, FixTabDelimiter)
+	}
+
+	if trimmed := strings.TrimRight(record, ",;"); trimmed != record {
+		record = trimmed
+		fixes = append(fixes, FixTrailingPunct)
+	}
+
+	if strings.Contains(record, n.config.TupleDelimiter) &&
+		(!strings.HasPrefix(record, "(") || !strings.HasSuffix(record, ")")) {
+		record = "(" + strings.TrimSuffix(strings.TrimPrefix(record, "("), ")") + ")"
+		fixes = append(fixes, FixMissingParentheses)
+	}
+
+	return record, fixes, false
+}
@@ -0,0 +1,169 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/model"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// TupleType describes a tuple type the parser understands
+type TupleType struct {
+	Name      string             // tuple type name, the first part of every tuple
+	NewParser func() TupleParser // creates a fresh parser for each record
+	Prompt    string             // step injected into the tuple system template, may use {TD}, {RD} and {CD}
+}
+
+// TupleRegistry maps tuple type names to their parsers and prompt fragments
+// A new registry contains the built-in intent, entity, language, sentiment and aspect_sentiment types
+type TupleRegistry struct {
+	mu    sync.RWMutex
+	types map[string]TupleType
+	order []string
+}
+
+// NewTupleRegistry creates a registry holding the built-in tuple types
+func NewTupleRegistry() *TupleRegistry {
+	r := &TupleRegistry{types: make(map[string]TupleType)}
+	for _, tupleType := range builtinTupleTypes() {
+		r.types[tupleType.Name] = tupleType
+		r.order = append(r.order, tupleType.Name)
+	}
+	return r
+}
+
+// builtinTupleTypes returns the tuple types described by the base system template
+func builtinTupleTypes() []TupleType {
+	return []TupleType{
+		{Name: "intent", NewParser: func() TupleParser { return &IntentParser{Intent: &model.Intent{}} }},
+		{Name: "entity", NewParser: func() TupleParser { return &EntityParser{Entity: &model.Entity{}} }},
+		{Name: "language", NewParser: func() TupleParser { return &LanguageParser{Language: &model.Language{}} }},
+		{Name: "sentiment", NewParser: func() TupleParser { return &SentimentParser{Sentiment: &model.Sentiment{}} }},
+		{Name: "aspect_sentiment", NewParser: func() TupleParser {
+			return &AspectSentimentParser{AspectSentiment: &model.AspectSentiment{}}
+		}},
+	}
+}
+
+// Register adds a custom tuple type
+// Built-in and already registered names cannot be replaced
+func (r *TupleRegistry) Register(tupleType TupleType) error {
+	name := strings.TrimSpace(tupleType.Name)
+	if name == "" {
+		return fmt.Errorf("tuple type name cannot be empty")
+	}
+	if strings.ContainsAny(name, "()\t\n") {
+		return fmt.Errorf("invalid tuple type name: %q", name)
+	}
+	if tupleType.NewParser == nil {
+		return fmt.Errorf("tuple type %s requires a parser factory", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.types[name]; exists {
+		return fmt.Errorf("tuple type already registered: %s", name)
+	}
+	tupleType.Name = name
+	r.types[name] = tupleType
+	r.order = append(r.order, name)
+	return nil
+}
+
+// RegisterExtension registers a tuple type in the generic
+// (name{TD}<label>{TD}<confidence>{TD}{metadata}) form, stored in NLUResponse.Extensions
+// With multiple set every tuple is appended, otherwise the last tuple wins
+func (r *TupleRegistry) RegisterExtension(name string, multiple bool, prompt string) error {
+	return r.Register(TupleType{
+		Name: name,
+		NewParser: func() TupleParser {
+			return &ExtensionParser{Name: name, Multiple: multiple, Extension: &model.Extension{}}
+		},
+		Prompt: prompt,
+	})
+}
+
+// Names returns the registered tuple type names in registration order
+func (r *TupleRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, len(r.order))
+	copy(names, r.order)
+	return names
+}
+
+// newParser creates the parser registered for the tuple type
+func (r *TupleRegistry) newParser(tupleType string) (TupleParser, error) {
+	r.mu.RLock()
+	registered, ok := r.types[tupleType]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown tuple type: %s", tupleType)
+	}
+	return registered.NewParser(), nil
+}
+
+// promptFragments renders the prompt steps of all custom tuple types, one line each
+func (r *TupleRegistry) promptFragments() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var b strings.Builder
+	for _, name := range r.order {
+		prompt := strings.TrimSpace(r.types[name].Prompt)
+		if prompt == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "- **%s:** %s\n", strings.ToUpper(name), prompt)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "<additional_tuples>\n" + b.String() + "</additional_tuples>"
+}
+
+// ExtensionParser parses custom tuples into NLUResponse.Extensions[Name]
+// Tuple format: (name<||>label<||>confidence<||>{metadata})
+type ExtensionParser struct {
+	Name     string
+	Multiple bool
+	*model.Extension
+}
+
+func (p *ExtensionParser) Parse(raw *RawTuple) error {
+	if len(raw.Parts) < 3 {
+		return fmt.Errorf("%s tuple requires at least 3 parts, got %d", p.Name, len(raw.Parts))
+	}
+
+	var err error
+	p.Extension.Label = strings.TrimSpace(raw.Parts[1])
+	if err = validateString(p.Extension.Label, 100, p.Name+" label"); err != nil {
+		return err
+	}
+
+	if p.Extension.Confidence, err = parseFloat(raw.Parts[2], "confidence"); err != nil {
+		return err
+	}
+
+	if len(raw.Parts) >= 4 {
+		if p.Extension.Metadata, err = parseMetadataJSON(raw.Parts[3]); err != nil {
+			return err
+		}
+	} else {
+		p.Extension.Metadata = make(map[string]any)
+	}
+
+	return nil
+}
+
+func (p *ExtensionParser) AddToResponse(response *model.NLUResponse) {
+	if response.Extensions == nil {
+		response.Extensions = make(map[string]any)
+	}
+	if !p.Multiple {
+		response.Extensions[p.Name] = *p.Extension
+		return
+	}
+	values, _ := response.Extensions[p.Name].([]model.Extension)
+	response.Extensions[p.Name] = append(values, *p.Extension)
+}
@@ -0,0 +1,80 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/model"
+	"fmt"
+	"strings"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// Repair reasons returned by RepairReasons
+const (
+	RepairReasonNoIntents         = "no intents were parsed"
+	RepairReasonNoSentiment       = "no sentiment was parsed"
+	RepairReasonNoLanguage        = "no language was parsed"
+	RepairReasonMissingCompletion = "the completion delimiter is missing"
+)
+
+// RepairReasons reports why a parsed response needs a repair round, empty when it is usable
+// Tuple failures recorded in ParsingMetadata are included with their parse errors
+func RepairReasons(response *model.NLUResponse) []string {
+	if response == nil {
+		return []string{RepairReasonNoIntents, RepairReasonNoSentiment, RepairReasonNoLanguage}
+	}
+
+	var reasons []string
+	if len(response.Intents) == 0 {
+		reasons = append(reasons, RepairReasonNoIntents)
+	}
+	if response.Sentiment.Label == "" {
+		reasons = append(reasons, RepairReasonNoSentiment)
+	}
+	if len(response.Languages) == 0 {
+		reasons = append(reasons, RepairReasonNoLanguage)
+	}
+	if completionSeen, ok := response.ParsingMetadata["completion_seen"].(bool); ok && !completionSeen {
+		reasons = append(reasons, RepairReasonMissingCompletion)
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	// Only worth listing tuple errors when the response is unusable anyway
+	if failures, ok := response.ParsingMetadata["failures"].([]TupleFailure); ok {
+		for _, failure := range failures {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", failure.Tuple, failure.Reason))
+		}
+	}
+	return reasons
+}
+
+// RepairMessages builds the follow-up messages asking the model to fix its previous output
+// Tool calls of the broken output are answered first, as chat APIs require a result for each call
+func (n *NLUProcessor) RepairMessages(brokenOutput *schema.Message, reasons []string) []*schema.Message {
+	var messages []*schema.Message
+	if brokenOutput != nil {
+		for _, call := range brokenOutput.ToolCalls {
+			messages = append(messages, schema.ToolMessage("received", call.ID, schema.WithToolName(call.Function.Name)))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("Your previous output could not be parsed:\n")
+	for _, reason := range reasons {
+		b.WriteString("- ")
+		b.WriteString(reason)
+		b.WriteString("\n")
+	}
+	switch n.config.OutputMode {
+	case OutputModeJSON:
+		b.WriteString("\nReturn the complete analysis again as a single valid JSON object following the schema, with no other text.")
+	case OutputModeTools:
+		b.WriteString("\nReport the complete analysis again by calling the report tools, including at least one intent and exactly one sentiment.")
+	default:
+		fmt.Fprintf(&b, "\nReturn the complete analysis again using only the tuple format from the instructions, "+
+			"separate records with %s and end with %s on its own line.", n.config.RecordDelimiter, n.config.CompletionDelimiter)
+	}
+
+	return append(messages, schema.UserMessage(b.String()))
+}
@@ -0,0 +1,281 @@
+package nlu
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultCatalogKey is the key reported for requests served by the fallback catalog
+const DefaultCatalogKey = "default"
+
+// CatalogSelector picks the intent/entity catalog of a request, empty fields select the fallback
+type CatalogSelector struct {
+	Tenant  string `json:"tenant,omitempty"`
+	Channel string `json:"channel,omitempty"`
+}
+
+// Keys returns the catalog keys to look up, most specific first:
+// "tenant/<tenant>/<channel>", "tenant/<tenant>" and "channel/<channel>"
+func (s CatalogSelector) Keys() []string {
+	var keys []string
+	if s.Tenant != "" && s.Channel != "" {
+		keys = append(keys, "tenant/"+s.Tenant+"/"+s.Channel)
+	}
+	if s.Tenant != "" {
+		keys = append(keys, "tenant/"+s.Tenant)
+	}
+	if s.Channel != "" {
+		keys = append(keys, "channel/"+s.Channel)
+	}
+	return keys
+}
+
+// String returns the selector in "tenant:channel" form, used as cache key and in logs
+func (s CatalogSelector) String() string {
+	return s.Tenant + ":" + s.Channel
+}
+
+// validate rejects selectors that would escape the catalog directory or the Redis key prefix
+func (s CatalogSelector) validate() error {
+	for _, part := range []string{s.Tenant, s.Channel} {
+		if strings.ContainsAny(part, `/\:`) || part == "." || part == ".." {
+			return fmt.Errorf("invalid catalog selector %q", s.String())
+		}
+	}
+	return nil
+}
+
+// CatalogSource loads the catalog stored under a key
+type CatalogSource interface {
+	// LoadCatalog returns the catalog of the key, nil without an error when the source has none
+	LoadCatalog(ctx context.Context, key string) (*Catalog, error)
+}
+
+// FileCatalogSource reads catalog files from a directory, key "tenant/shop1/line" is tenant/shop1/line.yaml
+type FileCatalogSource struct {
+	Dir string
+}
+
+// LoadCatalog reads <Dir>/<key>.yaml
+func (f *FileCatalogSource) LoadCatalog(ctx context.Context, key string) (*Catalog, error) {
+	path := filepath.Join(f.Dir, filepath.FromSlash(key)+".yaml")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return LoadCatalogFile(path)
+}
+
+// RedisCatalogSource reads catalogs in the catalog file format from Redis strings under prefix+key
+type RedisCatalogSource struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisCatalogSource connects to the Redis server at redisURL
+func NewRedisCatalogSource(ctx context.Context, redisURL, prefix string) (*RedisCatalogSource, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Redis URL: %v", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+	return &RedisCatalogSource{client: client, prefix: prefix}, nil
+}
+
+// LoadCatalog parses the YAML stored under prefix+key
+func (r *RedisCatalogSource) LoadCatalog(ctx context.Context, key string) (*Catalog, error) {
+	data, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load catalog %s: %v", key, err)
+	}
+	catalog, err := ParseCatalogYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("catalog %s: %v", key, err)
+	}
+	return catalog, nil
+}
+
+// PromptRenderer renders the system prompt of a catalog in a prompt version, empty for the configured one,
+// with rendered few-shot examples, empty for the static examples of the template
+type PromptRenderer func(catalog *Catalog, version, examples string) (string, error)
+
+// ResolvedCatalog is the catalog of a request with its rendered system prompt
+type ResolvedCatalog struct {
+	Key          string // matched catalog key, DefaultCatalogKey for the fallback
+	Catalog      *Catalog
+	SystemPrompt string // prompt of the configured version
+
+	prompts sync.Map // prompts of other versions, rendered on first use
+}
+
+// resolvedEntry is a cached resolution and when it has to be looked up again
+type resolvedEntry struct {
+	selector  string
+	resolved  *ResolvedCatalog
+	expiresAt time.Time
+}
+
+// CatalogResolver looks up the catalog of each request in its sources and caches the
+// resolved catalog with its rendered system prompt for ttl
+// Selectors come from requests, so the cache keeps at most maxEntries of the most recently used ones
+type CatalogResolver struct {
+	sources    []CatalogSource
+	render     PromptRenderer
+	ttl        time.Duration
+	maxEntries int
+	fallback   *ResolvedCatalog
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // elements of recent by selector
+	recent  *list.List               // *resolvedEntry, most recently used first
+}
+
+// NewCatalogResolver creates a resolver over sources, tried in order for every key
+// The fallback catalog serves requests no source has a catalog for; its prompt is rendered here,
+// so a broken prompt fails at startup. maxEntries of 0 or less leaves the cache unbounded.
+func NewCatalogResolver(fallback *Catalog, render PromptRenderer, ttl time.Duration, maxEntries int, sources ...CatalogSource) (*CatalogResolver, error) {
+	prompt, err := render(fallback, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return &CatalogResolver{
+		sources:    sources,
+		render:     render,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		fallback:   &ResolvedCatalog{Key: DefaultCatalogKey, Catalog: fallback, SystemPrompt: prompt},
+		entries:    make(map[string]*list.Element),
+		recent:     list.New(),
+	}, nil
+}
+
+// Resolve returns the most specific catalog of the selector, or the fallback when there is none
+// When a source fails after the cache entry expired, the previous resolution is kept
+func (r *CatalogResolver) Resolve(ctx context.Context, selector CatalogSelector) (*ResolvedCatalog, error) {
+	if selector.Tenant == "" && selector.Channel == "" {
+		return r.fallback, nil
+	}
+	if err := selector.validate(); err != nil {
+		return nil, err
+	}
+
+	cacheKey := selector.String()
+	cached, ok := r.cached(cacheKey)
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.resolved, nil
+	}
+
+	resolved, err := r.lookup(ctx, selector)
+	if err != nil {
+		if ok {
+			return cached.resolved, nil
+		}
+		return nil, err
+	}
+
+	r.store(cacheKey, resolved)
+	return resolved, nil
+}
+
+// cached returns the cache entry of a selector, marking it as recently used
+func (r *CatalogResolver) cached(selector string) (resolvedEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	element, ok := r.entries[selector]
+	if !ok {
+		return resolvedEntry{}, false
+	}
+	r.recent.MoveToFront(element)
+	return *element.Value.(*resolvedEntry), true
+}
+
+// store caches the resolution of a selector, evicting the least recently used entries over maxEntries
+func (r *CatalogResolver) store(selector string, resolved *ResolvedCatalog) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := &resolvedEntry{selector: selector, resolved: resolved, expiresAt: time.Now().Add(r.ttl)}
+	if element, ok := r.entries[selector]; ok {
+		element.Value = entry
+		r.recent.MoveToFront(element)
+		return
+	}
+	r.entries[selector] = r.recent.PushFront(entry)
+	for r.maxEntries > 0 && r.recent.Len() > r.maxEntries {
+		oldest := r.recent.Back()
+		r.recent.Remove(oldest)
+		delete(r.entries, oldest.Value.(*resolvedEntry).selector)
+	}
+}
+
+// lookup loads the first catalog found for the selector keys and renders its prompt
+func (r *CatalogResolver) lookup(ctx context.Context, selector CatalogSelector) (*ResolvedCatalog, error) {
+	for _, key := range selector.Keys() {
+		for _, source := range r.sources {
+			catalog, err := source.LoadCatalog(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if catalog == nil {
+				continue
+			}
+			prompt, err := r.render(catalog, "", "")
+			if err != nil {
+				return nil, fmt.Errorf("catalog %s: %v", key, err)
+			}
+			return &ResolvedCatalog{Key: key, Catalog: catalog, SystemPrompt: prompt}, nil
+		}
+	}
+	return r.fallback, nil
+}
+
+// SystemPrompt returns the prompt of a resolved catalog in a prompt version, empty for the configured one
+// Prompts are cached with the resolved catalog and rendered again when the catalog is reloaded
+func (r *CatalogResolver) SystemPrompt(resolved *ResolvedCatalog, version string) (string, error) {
+	if version == "" {
+		return resolved.SystemPrompt, nil
+	}
+	if prompt, ok := resolved.prompts.Load(version); ok {
+		return prompt.(string), nil
+	}
+	prompt, err := r.render(resolved.Catalog, version, "")
+	if err != nil {
+		return "", fmt.Errorf("catalog %s: %v", resolved.Key, err)
+	}
+	resolved.prompts.Store(version, prompt)
+	return prompt, nil
+}
+
+// SystemPromptWithExamples returns the prompt of a resolved catalog with the few-shot examples
+// retrieved for a query; it is rendered per request, without examples the cached prompt is used
+func (r *CatalogResolver) SystemPromptWithExamples(resolved *ResolvedCatalog, version, examples string) (string, error) {
+	if examples == "" {
+		return r.SystemPrompt(resolved, version)
+	}
+	prompt, err := r.render(resolved.Catalog, version, examples)
+	if err != nil {
+		return "", fmt.Errorf("catalog %s: %v", resolved.Key, err)
+	}
+	return prompt, nil
+}
+
+// Invalidate drops the cached resolutions, the next request of every selector loads its catalog again
+func (r *CatalogResolver) Invalidate() {
+	r.mu.Lock()
+	r.entries = make(map[string]*list.Element)
+	r.recent.Init()
+	r.mu.Unlock()
+}
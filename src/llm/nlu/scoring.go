@@ -0,0 +1,106 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/model"
+	"math"
+)
+
+// ImportanceScorer computes NLUResponse.ImportanceScore from the parsed response
+// The breakdown is written to ParsingMetadata["importance_breakdown"]
+type ImportanceScorer interface {
+	Score(response *model.NLUResponse) (float64, map[string]float64)
+}
+
+// ImportanceWeights configures WeightedScorer
+// The defaults reproduce the original 60% confidence + 40% priority formula
+type ImportanceWeights struct {
+	Confidence             float64 // weight of the primary intent confidence
+	Priority               float64 // weight of the primary intent business priority
+	SecondaryIntent        float64 // weight of the mean weighted score of the other intents
+	NegativeSentimentBoost float64 // added for negative sentiment, scaled by sentiment confidence
+	EntityBonus            float64 // added per extracted entity
+	MaxEntityBonus         float64 // cap of the total entity bonus
+}
+
+// DefaultImportanceWeights returns the weights of the original scoring formula
+func DefaultImportanceWeights() ImportanceWeights {
+	return ImportanceWeights{
+		Confidence: ConfidenceWeight,
+		Priority:   PriorityWeight,
+	}
+}
+
+// ImportanceWeightsFromConfig reads the scoring weights from NLU configuration
+func ImportanceWeightsFromConfig(nluConfig *model.NLUConfig) ImportanceWeights {
+	return ImportanceWeights{
+		Confidence:             nluConfig.ImportanceConfidenceWeight,
+		Priority:               nluConfig.ImportancePriorityWeight,
+		SecondaryIntent:        nluConfig.ImportanceSecondaryWeight,
+		NegativeSentimentBoost: nluConfig.ImportanceNegativeBoost,
+		EntityBonus:            nluConfig.ImportanceEntityBonus,
+		MaxEntityBonus:         nluConfig.ImportanceMaxEntityBonus,
+	}
+}
+
+// WeightedScorer scores a response as a weighted sum of intent, sentiment and entity signals
+type WeightedScorer struct {
+	Weights ImportanceWeights
+}
+
+// NewWeightedScorer creates a weighted scorer with the given weights
+func NewWeightedScorer(weights ImportanceWeights) *WeightedScorer {
+	return &WeightedScorer{Weights: weights}
+}
+
+// Score computes the importance score clamped to [0, 1] and its components
+func (s *WeightedScorer) Score(response *model.NLUResponse) (float64, map[string]float64) {
+	w := s.Weights
+	breakdown := map[string]float64{
+		"primary_intent":     0,
+		"secondary_intents":  0,
+		"negative_sentiment": 0,
+		"entity_bonus":       0,
+	}
+
+	if len(response.Intents) > 0 {
+		// Primary intent is the highest confidence one, as for PrimaryIntent
+		primaryIdx := 0
+		for i, intent := range response.Intents {
+			if intent.Confidence > response.Intents[primaryIdx].Confidence {
+				primaryIdx = i
+			}
+		}
+		primary := response.Intents[primaryIdx]
+		breakdown["primary_intent"] = primary.Confidence*w.Confidence + primary.Priority*w.Priority
+
+		if len(response.Intents) > 1 && w.SecondaryIntent != 0 {
+			secondary := 0.0
+			for i, intent := range response.Intents {
+				if i != primaryIdx {
+					secondary += intent.Confidence*w.Confidence + intent.Priority*w.Priority
+				}
+			}
+			breakdown["secondary_intents"] = w.SecondaryIntent * secondary / float64(len(response.Intents)-1)
+		}
+	}
+
+	if response.Sentiment.Label == "negative" {
+		breakdown["negative_sentiment"] = w.NegativeSentimentBoost * response.Sentiment.Confidence
+	}
+
+	if len(response.Entities) > 0 {
+		bonus := w.EntityBonus * float64(len(response.Entities))
+		if w.MaxEntityBonus > 0 {
+			bonus = math.Min(bonus, w.MaxEntityBonus)
+		}
+		breakdown["entity_bonus"] = bonus
+	}
+
+	total := 0.0
+	for _, component := range breakdown {
+		total += component
+	}
+	total = clampUnit(total)
+	breakdown["total"] = total
+	return total, breakdown
+}
@@ -0,0 +1,251 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/model"
+	"unicode"
+)
+
+// Span verification statuses stored in Entity.Metadata["span_status"]
+const (
+	SpanStatusVerified     = "verified"     // model offsets were correct rune offsets
+	SpanStatusRepaired     = "repaired"     // model offsets were wrong or in another unit and were recomputed
+	SpanStatusLocated      = "located"      // model gave no offsets, they were computed from the text
+	SpanStatusHallucinated = "hallucinated" // value does not occur in the text at all
+)
+
+// Offset units the model may have used for entity_position
+const (
+	offsetUnitRune     = "rune"
+	offsetUnitByte     = "byte"
+	offsetUnitGrapheme = "grapheme"
+)
+
+// spanIndex maps between rune, byte and grapheme offsets of the analysed text
+type spanIndex struct {
+	runes         []rune
+	byteToRune    map[int]int
+	graphemeStart []int // rune offset where each grapheme cluster starts
+}
+
+// VerifyEntitySpans checks that every entity value occurs in the analysed text and
+// rewrites Entity.Position as 0-based [start, end) rune offsets
+//
+// Repeated values are resolved to their occurrences in order of extraction. Offsets the
+// model reported as bytes or grapheme clusters are recognised and converted; entities whose
+// value is absent from the text are kept but flagged as hallucinated. Aspect sentiment spans
+// are verified the same way, with their counts in ParsingMetadata["aspect_span_verification"].
+func VerifyEntitySpans(response *model.NLUResponse, text string) {
+	if response == nil {
+		return
+	}
+
+	index := newSpanIndex(text)
+	used := make(map[string]map[int]bool)
+	counts := map[string]int{
+		SpanStatusVerified:     0,
+		SpanStatusRepaired:     0,
+		SpanStatusLocated:      0,
+		SpanStatusHallucinated: 0,
+	}
+
+	for i := range response.Entities {
+		entity := &response.Entities[i]
+		if entity.Metadata == nil {
+			entity.Metadata = make(map[string]any)
+		}
+
+		status := index.resolve(entity, used)
+		entity.Metadata["span_status"] = status
+		counts[status]++
+	}
+
+	if response.ParsingMetadata == nil {
+		response.ParsingMetadata = make(map[string]any)
+	}
+	response.ParsingMetadata["span_verification"] = counts
+
+	if len(response.AspectSentiments) > 0 {
+		response.ParsingMetadata["aspect_span_verification"] = index.verifyAspects(response.AspectSentiments)
+	}
+}
+
+// verifyAspects resolves aspect sentiment spans like entity values and returns the status counts
+func (idx *spanIndex) verifyAspects(aspects []model.AspectSentiment) map[string]int {
+	used := make(map[string]map[int]bool)
+	counts := make(map[string]int)
+	for i := range aspects {
+		aspect := &aspects[i]
+		if aspect.Metadata == nil {
+			aspect.Metadata = make(map[string]any)
+		}
+
+		// Resolve through an entity view of the aspect span, sharing the metadata map
+		span := &model.Entity{Value: aspect.Span, Position: aspect.Position, Metadata: aspect.Metadata}
+		status := idx.resolve(span, used)
+		aspect.Position = span.Position
+		aspect.Metadata["span_status"] = status
+		counts[status]++
+	}
+	return counts
+}
+
+// newSpanIndex precomputes offset conversions for text
+func newSpanIndex(text string) *spanIndex {
+	index := &spanIndex{
+		runes:      []rune(text),
+		byteToRune: make(map[int]int, len(text)+1),
+	}
+
+	runeIdx := 0
+	for byteIdx, r := range text {
+		index.byteToRune[byteIdx] = runeIdx
+		if runeIdx == 0 || !isGraphemeExtend(r) {
+			index.graphemeStart = append(index.graphemeStart, runeIdx)
+		}
+		runeIdx++
+	}
+	index.byteToRune[len(text)] = runeIdx
+	index.graphemeStart = append(index.graphemeStart, runeIdx)
+	return index
+}
+
+// isGraphemeExtend approximates grapheme cluster extension: combining marks
+// (Thai vowels above/below and tone marks), zero-width joiners and variation selectors
+func isGraphemeExtend(r rune) bool {
+	return unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || unicode.Is(unicode.Mc, r) ||
+		r == '\u200d' || unicode.Is(unicode.Variation_Selector, r)
+}
+
+// resolve picks the occurrence of the entity value that best matches the model's claim
+func (idx *spanIndex) resolve(entity *model.Entity, used map[string]map[int]bool) string {
+	occurrences := idx.find(entity.Value)
+	claimed := entity.Position
+
+	if len(occurrences) == 0 {
+		if claimed != nil {
+			entity.Metadata["model_position"] = claimed
+		}
+		entity.Metadata["hallucinated"] = true
+		entity.Position = nil
+		delete(entity.Metadata, "entity_position")
+		return SpanStatusHallucinated
+	}
+
+	if used[entity.Value] == nil {
+		used[entity.Value] = make(map[int]bool)
+	}
+	taken := used[entity.Value]
+
+	// Prefer the occurrence the model pointed at, in whichever unit it used
+	chosen, unit := -1, ""
+	if len(claimed) == 2 {
+		for _, u := range []string{offsetUnitRune, offsetUnitByte, offsetUnitGrapheme} {
+			start, end, ok := idx.toRunes(claimed[0], claimed[1], u)
+			if !ok {
+				continue
+			}
+			for _, occ := range occurrences {
+				if occ[0] == start && occ[1] == end && !taken[start] {
+					chosen, unit = start, u
+					break
+				}
+			}
+			if chosen >= 0 {
+				break
+			}
+		}
+	}
+
+	// Otherwise take the next unused occurrence in text order
+	if chosen < 0 {
+		chosen = occurrences[0][0]
+		for _, occ := range occurrences {
+			if !taken[occ[0]] {
+				chosen = occ[0]
+				break
+			}
+		}
+	}
+	taken[chosen] = true
+
+	var span []int
+	for _, occ := range occurrences {
+		if occ[0] == chosen {
+			span = []int{occ[0], occ[1]}
+			break
+		}
+	}
+
+	entity.Position = span
+	entity.Metadata["entity_position"] = span
+
+	switch {
+	case unit == offsetUnitRune:
+		return SpanStatusVerified
+	case claimed == nil:
+		return SpanStatusLocated
+	default:
+		entity.Metadata["model_position"] = claimed
+		if unit != "" {
+			entity.Metadata["model_position_unit"] = unit
+		}
+		return SpanStatusRepaired
+	}
+}
+
+// find returns all [start, end) rune spans where value occurs, exact matches first
+// Falls back to case-insensitive matching when there is no exact occurrence
+func (idx *spanIndex) find(value string) [][2]int {
+	needle := []rune(value)
+	if len(needle) == 0 || len(needle) > len(idx.runes) {
+		return nil
+	}
+
+	for _, fold := range []bool{false, true} {
+		var spans [][2]int
+		for start := 0; start+len(needle) <= len(idx.runes); start++ {
+			if runesEqual(idx.runes[start:start+len(needle)], needle, fold) {
+				spans = append(spans, [2]int{start, start + len(needle)})
+			}
+		}
+		if len(spans) > 0 {
+			return spans
+		}
+	}
+	return nil
+}
+
+// toRunes converts a [start, end) span in the given unit to rune offsets
+func (idx *spanIndex) toRunes(start, end int, unit string) (int, int, bool) {
+	if start < 0 || end <= start {
+		return 0, 0, false
+	}
+
+	switch unit {
+	case offsetUnitRune:
+		return start, end, end <= len(idx.runes)
+	case offsetUnitByte:
+		s, okStart := idx.byteToRune[start]
+		e, okEnd := idx.byteToRune[end]
+		return s, e, okStart && okEnd
+	case offsetUnitGrapheme:
+		if end >= len(idx.graphemeStart) {
+			return 0, 0, false
+		}
+		return idx.graphemeStart[start], idx.graphemeStart[end], true
+	}
+	return 0, 0, false
+}
+
+// runesEqual compares two rune slices, optionally with simple case folding
+func runesEqual(a, b []rune, fold bool) bool {
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		if !fold || unicode.ToLower(a[i]) != unicode.ToLower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,291 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/model"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// StreamEvent is emitted for every record parsed from a streamed response
+// The final event has Complete set and carries the response with derived fields
+type StreamEvent struct {
+	Type     string             // tuple type of the parsed record, empty on the final event
+	Record   TupleParser        // type-specific parser holding the parsed record
+	Complete bool               // true once the completion delimiter arrived or the stream ended
+	Response *model.NLUResponse // finalized response, only set when Complete is true
+}
+
+// StreamParser incrementally parses tuple records from streamed model output
+// Records are emitted as soon as their record delimiter arrives
+type StreamParser struct {
+	session *parseSession
+	buffer  strings.Builder
+	done    bool
+
+	// newlineTuple is set when the previous tuple was terminated by a newline instead of a record delimiter
+	newlineTuple bool
+}
+
+// NewStreamParser creates an incremental parser using the processor configuration
+func (n *NLUProcessor) NewStreamParser() *StreamParser {
+	return &StreamParser{session: n.newParseSession()}
+}
+
+// Feed appends a chunk of model output and returns events for every record it completed
+// Once the completion delimiter has been seen, the final event is returned and further chunks only
+// add FixTrailingText to the format fixes of its response
+// In strict mode the error of the finalized response is returned with the final event
+// JSON output is only decoded once the stream ends, so Feed buffers it without emitting events
+func (p *StreamParser) Feed(chunk string) ([]*StreamEvent, error) {
+	if p.done {
+		p.trailing(chunk)
+		return nil, nil
+	}
+	p.buffer.WriteString(chunk)
+
+	processor := p.session.processor
+	if processor.config.OutputMode == OutputModeJSON {
+		return nil, nil
+	}
+	pending := p.buffer.String()
+	var events []*StreamEvent
+
+	for {
+		recordIdx, boundary := nextMarker(pending, processor.recordBoundaries())
+		completionIdx, marker := nextMarker(pending, processor.completionMarkers())
+
+		// Completion delimiter arrives before the next record boundary: flush and finalize
+		if completionIdx >= 0 && (recordIdx < 0 || completionIdx < recordIdx) {
+			if event := p.emit(pending[:completionIdx], ""); event != nil {
+				events = append(events, event)
+			}
+			if marker == placeholderCompletionDelimiter {
+				p.session.addFixes(FixPlaceholder)
+			}
+			p.session.completionSeen = true
+			p.buffer.Reset()
+			p.buffer.WriteString(pending[completionIdx+len(marker):])
+			if isTrailingText(p.buffer.String()) {
+				p.session.addFixes(FixTrailingText)
+			}
+			final, err := p.finish()
+			return append(events, final), err
+		}
+
+		if recordIdx < 0 {
+			break
+		}
+
+		if event := p.emit(pending[:recordIdx], boundary); event != nil {
+			events = append(events, event)
+		}
+		pending = pending[recordIdx+len(boundary):]
+	}
+
+	p.buffer.Reset()
+	p.buffer.WriteString(pending)
+	return events, nil
+}
+
+// Finish flushes any buffered partial record and returns the remaining events
+// The last event is always the final one; calling Finish after completion returns it again
+func (p *StreamParser) Finish() ([]*StreamEvent, error) {
+	if p.done {
+		return []*StreamEvent{{Complete: true, Response: p.session.response}}, nil
+	}
+
+	if p.session.processor.config.OutputMode == OutputModeJSON {
+		p.session.addJSON(p.buffer.String())
+		p.buffer.Reset()
+		final, err := p.finish()
+		return []*StreamEvent{final}, err
+	}
+
+	var events []*StreamEvent
+	if event := p.emit(p.buffer.String(), ""); event != nil {
+		events = append(events, event)
+	}
+	p.buffer.Reset()
+	final, err := p.finish()
+	return append(events, final), err
+}
+
+// trailing collects text after the completion delimiter and records it on the finalized response
+func (p *StreamParser) trailing(chunk string) {
+	p.buffer.WriteString(chunk)
+	if slices.Contains(p.session.fixes, FixTrailingText) || !isTrailingText(p.buffer.String()) {
+		return
+	}
+	p.session.addFixes(FixTrailingText)
+	if p.session.processor.config.FormatRecovery {
+		p.session.response.ParsingMetadata["format_fixes"] = slices.Clone(p.session.fixes)
+	}
+}
+
+// Response returns the response accumulated so far, derived fields are only set after completion
+func (p *StreamParser) Response() *model.NLUResponse {
+	return p.session.response
+}
+
+// emit parses a single record and wraps it in an event, nil when skipped or failed
+// boundary is the marker that terminated the record, used to detect newline-separated tuples
+func (p *StreamParser) emit(record string, boundary string) *StreamEvent {
+	processor := p.session.processor
+	isTuple := processor.looksLikeTuple(record)
+	switch {
+	case isTuple && p.newlineTuple:
+		p.session.addFixes(FixNewlineDelimiter)
+	case strings.TrimSpace(record) == "" && boundary == processor.config.RecordDelimiter:
+		// "(...)\n##" still uses the record delimiter, only on its own line
+		p.newlineTuple = false
+	}
+	if isTuple {
+		p.newlineTuple = boundary == "\n"
+	}
+	if boundary == placeholderRecordDelimiter {
+		p.session.addFixes(FixPlaceholder)
+	}
+
+	parser, err := p.session.addRecord(record)
+	if err != nil || parser == nil {
+		return nil
+	}
+	return &StreamEvent{
+		Type:   processor.recordType(record),
+		Record: parser,
+	}
+}
+
+// finish finalizes derived fields and marks the parser as done
+func (p *StreamParser) finish() (*StreamEvent, error) {
+	p.done = true
+	response, err := p.session.finalize()
+	return &StreamEvent{
+		Complete: true,
+		Response: response,
+	}, err
+}
+
+// recordType extracts the tuple type name from a raw record
+func (n *NLUProcessor) recordType(record string) string {
+	record, _, _ = n.normalizeRecord(strings.TrimSpace(record))
+	record = strings.TrimLeft(record, "(")
+	if idx := strings.Index(record, n.config.TupleDelimiter); idx >= 0 {
+		record = record[:idx]
+	}
+	return strings.TrimSpace(record)
+}
+
+// ParseStream consumes streamed model messages and emits parsed records as they arrive
+// The returned reader yields a final event with Complete set, then io.EOF
+// In strict mode a *ParseError is delivered instead of the final event
+func (n *NLUProcessor) ParseStream(sr *schema.StreamReader[*schema.Message]) *schema.StreamReader[*StreamEvent] {
+	out, writer := schema.Pipe[*StreamEvent](10)
+
+	if n.config.OutputMode == OutputModeTools {
+		go n.parseToolCallStream(sr, writer)
+		return out
+	}
+
+	parser := n.NewStreamParser()
+
+	go func() {
+		defer writer.Close()
+		defer sr.Close()
+
+		completed, first := false, true
+		for {
+			chunk, err := sr.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				writer.Send(nil, err)
+				return
+			}
+			if chunk == nil || completed {
+				// Drain the remainder of the stream after completion
+				continue
+			}
+			if first {
+				// A fallback chain names the answering model on the first chunk
+				parser.session.processor = n.forAnswer(chunk)
+				first = false
+			}
+
+			events, err := parser.Feed(chunk.Content)
+			if sendStreamEvents(writer, events, err) {
+				return
+			}
+			completed = len(events) > 0 && events[len(events)-1].Complete
+		}
+
+		if !completed {
+			events, err := parser.Finish()
+			sendStreamEvents(writer, events, err)
+		}
+	}()
+
+	return out
+}
+
+// parseToolCallStream concatenates streamed tool call fragments and parses them once the stream ends
+// Tool call arguments are only valid JSON when complete, so no per-record events are emitted
+func (n *NLUProcessor) parseToolCallStream(sr *schema.StreamReader[*schema.Message], writer *schema.StreamWriter[*StreamEvent]) {
+	defer writer.Close()
+	defer sr.Close()
+
+	var chunks []*schema.Message
+	for {
+		chunk, err := sr.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writer.Send(nil, err)
+			return
+		}
+		if chunk != nil {
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	message := &schema.Message{Role: schema.Assistant}
+	if len(chunks) > 0 {
+		concatenated, err := schema.ConcatMessages(chunks)
+		if err != nil {
+			writer.Send(nil, err)
+			return
+		}
+		message = concatenated
+	}
+
+	parser := n.forAnswer(message).NewStreamParser()
+	parser.session.addToolCalls(message)
+	final, err := parser.finish()
+	sendStreamEvents(writer, []*StreamEvent{final}, err)
+}
+
+// sendStreamEvents forwards parsed events, replacing the final event with err when set
+// Returns true when the reader side has been closed
+func sendStreamEvents(writer *schema.StreamWriter[*StreamEvent], events []*StreamEvent, err error) bool {
+	for _, event := range events {
+		if event.Complete && err != nil {
+			return writer.Send(nil, err)
+		}
+		if writer.Send(event, nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNLUStream parses streamed model output using a processor with default configuration
+func ParseNLUStream(sr *schema.StreamReader[*schema.Message]) *schema.StreamReader[*StreamEvent] {
+	processor := NewNLUProcessor()
+	return processor.ParseStream(sr)
+}
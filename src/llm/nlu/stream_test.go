@@ -0,0 +1,151 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/model"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+const (
+	fixtureIntent    = "(intent<||>product_inquiry<||>0.9<||>0.8<||>{\"extracted_from\":\"default\"})"
+	fixtureEntity    = "(entity<||>product<||>shoes<||>0.85<||>{\"entity_position\":[9,14]})"
+	fixtureLanguage  = "(language<||>eng<||>0.95<||>1<||>{\"script\":\"Latn\"})"
+	fixtureSentiment = "(sentiment<||>neutral<||>0.8<||>{\"polarity\":0.0})"
+)
+
+// parityFixtures are model outputs the batch and the stream parser have to agree on
+var parityFixtures = []struct {
+	name     string
+	content  string
+	recovery bool
+}{
+	{
+		name:     "clean",
+		content:  fixtureIntent + "##" + fixtureEntity + "##" + fixtureLanguage + "##" + fixtureSentiment + "##<|COMPLETE|>",
+		recovery: true,
+	},
+	{
+		name:     "code fence",
+		content:  "```\n" + fixtureIntent + "##\n" + fixtureLanguage + "##\n" + fixtureSentiment + "##\n<|COMPLETE|>\n```",
+		recovery: true,
+	},
+	{
+		name:     "newline records",
+		content:  fixtureIntent + "\n" + fixtureEntity + "\n" + fixtureSentiment + "\n<|COMPLETE|>",
+		recovery: true,
+	},
+	{
+		name: "placeholders",
+		content: "(intent{TD}product_inquiry{TD}0.9{TD}0.8{TD}{}){RD}" +
+			"(sentiment{TD}neutral{TD}0.8{TD}{}){RD}{CD}",
+		recovery: true,
+	},
+	{
+		name:     "completion after last tuple",
+		content:  fixtureIntent + "##" + fixtureSentiment + "<|COMPLETE|>",
+		recovery: true,
+	},
+	{
+		name:     "text after completion",
+		content:  fixtureIntent + "##" + fixtureSentiment + "##<|COMPLETE|> hope this helps",
+		recovery: true,
+	},
+	{
+		name:     "text after completion without records",
+		content:  fixtureIntent + "##" + fixtureSentiment + "<|COMPLETE|>\nLet me know if you need more.\n" + fixtureEntity,
+		recovery: true,
+	},
+	{
+		name:     "missing completion",
+		content:  fixtureIntent + "##" + fixtureSentiment + "##",
+		recovery: true,
+	},
+	{
+		name:     "completion after last tuple without recovery",
+		content:  fixtureIntent + "##" + fixtureSentiment + "<|COMPLETE|>",
+		recovery: false,
+	},
+}
+
+func TestStreamParserMatchesParseResponse(t *testing.T) {
+	for _, fixture := range parityFixtures {
+		processor := NewNLUProcessor(WithFormatRecovery(fixture.recovery))
+		batch, batchErr := processor.ParseResponse(fixture.content)
+		if batchErr != nil {
+			t.Fatalf("%s: ParseResponse: %v", fixture.name, batchErr)
+		}
+
+		for _, size := range []int{1, 2, 5, 0} {
+			t.Run(fixture.name+"/chunk "+chunkLabel(size), func(t *testing.T) {
+				streamed, err := feedChunks(processor, fixture.content, size)
+				if err != nil {
+					t.Fatalf("Feed: %v", err)
+				}
+				if got, want := intentSummary(streamed), intentSummary(batch); !reflect.DeepEqual(got, want) {
+					t.Errorf("intents = %v, want %v", got, want)
+				}
+				for _, key := range []string{"status", "completion_seen"} {
+					if got, want := streamed.ParsingMetadata[key], batch.ParsingMetadata[key]; got != want {
+						t.Errorf("%s = %v, want %v", key, got, want)
+					}
+				}
+				if got, want := sortedFixes(streamed), sortedFixes(batch); !reflect.DeepEqual(got, want) {
+					t.Errorf("format_fixes = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+// feedChunks streams content through a StreamParser in chunks of size runes, 0 for the whole content
+func feedChunks(processor *NLUProcessor, content string, size int) (*model.NLUResponse, error) {
+	parser := processor.NewStreamParser()
+	runes := []rune(content)
+	if size <= 0 {
+		size = len(runes)
+	}
+	var final *StreamEvent
+	for start := 0; start < len(runes); start += size {
+		events, err := parser.Feed(string(runes[start:min(start+size, len(runes))]))
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 && events[len(events)-1].Complete {
+			final = events[len(events)-1]
+		}
+	}
+	if final == nil {
+		events, err := parser.Finish()
+		if err != nil {
+			return nil, err
+		}
+		final = events[len(events)-1]
+	}
+	return final.Response, nil
+}
+
+// chunkLabel names a chunk size in subtest names
+func chunkLabel(size int) string {
+	if size <= 0 {
+		return "whole"
+	}
+	return strconv.Itoa(size)
+}
+
+// intentSummary returns the intent names and confidences of a response
+func intentSummary(response *model.NLUResponse) []string {
+	summary := make([]string, 0, len(response.Intents))
+	for _, intent := range response.Intents {
+		summary = append(summary, intent.Name+"="+strconv.FormatFloat(intent.Confidence, 'f', -1, 64))
+	}
+	return summary
+}
+
+// sortedFixes returns the recorded format fixes in sorted order, each fix is recorded once in
+// the order it was found, which differs between whole content and record by record parsing
+func sortedFixes(response *model.NLUResponse) []string {
+	fixes, _ := response.ParsingMetadata["format_fixes"].([]string)
+	return slices.Sorted(slices.Values(fixes))
+}
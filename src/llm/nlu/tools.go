@@ -0,0 +1,219 @@
+package nlu
+
+import (
+	"eino_llm_poc/src/llm/chatmodel"
+	"eino_llm_poc/src/model"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// OutputModeTools extracts NLU results through function calls instead of free-form output
+const OutputModeTools OutputMode = "tools"
+
+// Tool names bound to the ChatModel in tools output mode
+const (
+	ToolReportIntent          = "report_intent"
+	ToolReportEntity          = "report_entity"
+	ToolReportLanguage        = "report_language"
+	ToolReportSentiment       = "report_sentiment"
+	ToolReportAspectSentiment = "report_aspect_sentiment"
+)
+
+// finishReasonLength is the finish_reason reported when generation hit the token limit
+const finishReasonLength = "length"
+
+// NLUToolInfos returns the tool definitions for tools output mode
+// When a catalog is given, intent names and entity types are restricted to its labels
+func NLUToolInfos(catalog *Catalog) []*schema.ToolInfo {
+	intent := schemaForType(reflect.TypeOf(model.Intent{}))
+	entity := schemaForType(reflect.TypeOf(model.Entity{}))
+	language := schemaForType(reflect.TypeOf(model.Language{}))
+	sentiment := schemaForType(reflect.TypeOf(model.Sentiment{}))
+	aspect := schemaForType(reflect.TypeOf(model.AspectSentiment{}))
+
+	sentiment.Properties["label"].Value.WithEnum("positive", "neutral", "negative")
+	aspect.Properties["label"].Value.WithEnum("positive", "neutral", "negative")
+	delete(entity.Properties, "normalized")
+	if catalog != nil {
+		intent.Properties["name"].Value.WithEnum(catalog.intentNames()...)
+		entity.Properties["type"].Value.WithEnum(catalog.entityNames()...)
+		aspect.Properties["aspect"].Value.WithEnum(catalog.entityNames()...)
+	}
+
+	return []*schema.ToolInfo{
+		{
+			Name:        ToolReportIntent,
+			Desc:        "Report one detected intent of the current message (call up to 3 times, most likely first). Use the configured priority as-is.",
+			ParamsOneOf: schema.NewParamsOneOfByOpenAPIV3(intent),
+		},
+		{
+			Name:        ToolReportEntity,
+			Desc:        "Report one entity literally present in the current message, with its 0-based [start, end) character position. Call once per occurrence.",
+			ParamsOneOf: schema.NewParamsOneOfByOpenAPIV3(entity),
+		},
+		{
+			Name:        ToolReportLanguage,
+			Desc:        "Report one language of the current message as a lowercase ISO 639-3 code. Exactly one language is primary.",
+			ParamsOneOf: schema.NewParamsOneOfByOpenAPIV3(language),
+		},
+		{
+			Name:        ToolReportSentiment,
+			Desc:        "Report the overall sentiment of the current message. Call exactly once.",
+			ParamsOneOf: schema.NewParamsOneOfByOpenAPIV3(sentiment),
+		},
+		{
+			Name:        ToolReportAspectSentiment,
+			Desc:        "Report the sentiment towards one aspect (entity type) of the current message, with the literal aspect span and its 0-based [start, end) character position. Call once per aspect with an opinion.",
+			ParamsOneOf: schema.NewParamsOneOfByOpenAPIV3(aspect),
+		},
+	}
+}
+
+// ParseToolCalls converts the tool calls of a model message into structured NLUResponse
+// Calls go through the same validation and derived-field logic as tuple records
+func (n *NLUProcessor) ParseToolCalls(message *schema.Message) (*model.NLUResponse, error) {
+	session := n.newParseSession()
+	session.addToolCalls(message)
+	return session.finalize()
+}
+
+// ParseMessage parses a model message according to the configured output mode
+func (n *NLUProcessor) ParseMessage(message *schema.Message) (*model.NLUResponse, error) {
+	if message == nil {
+		return nil, fmt.Errorf("nil model message")
+	}
+	n = n.forAnswer(message)
+	var response *model.NLUResponse
+	var err error
+	if n.config.OutputMode == OutputModeTools {
+		response, err = n.ParseToolCalls(message)
+	} else {
+		response, err = n.ParseResponse(message.Content)
+	}
+	n.recordTruncation(response, message)
+	recordAnsweredBy(response, message)
+	return response, err
+}
+
+// forAnswer returns the processor calibrating with the calibrators of the model that answered,
+// as recorded by a fallback chain under chatmodel.ExtraAnsweredBy
+func (n *NLUProcessor) forAnswer(message *schema.Message) *NLUProcessor {
+	answeredBy, ok := message.Extra[chatmodel.ExtraAnsweredBy].(string)
+	if !ok || n.calibrations == nil {
+		return n
+	}
+	clone := *n
+	// The chain labels models "provider:model", calibration files may key them by model name alone
+	clone.calibration = n.calibrations.ForModel(answeredBy)
+	if _, modelName, found := strings.Cut(answeredBy, ":"); found && clone.calibration == nil {
+		clone.calibration = n.calibrations.ForModel(modelName)
+	}
+	return &clone
+}
+
+// recordAnsweredBy copies the model a fallback chain got the answer from, and the models it
+// fell back from, into ParsingMetadata["answered_by"] and ["fallback_attempts"]
+func recordAnsweredBy(response *model.NLUResponse, message *schema.Message) {
+	if response == nil {
+		return
+	}
+	if answeredBy, ok := message.Extra[chatmodel.ExtraAnsweredBy].(string); ok {
+		response.ParsingMetadata["answered_by"] = answeredBy
+	}
+	if attempts, ok := message.Extra[chatmodel.ExtraFallbackAttempts].([]chatmodel.FallbackAttempt); ok {
+		response.ParsingMetadata["fallback_attempts"] = attempts
+	}
+}
+
+// requiredToolCalls are the tools a complete turn calls, binding the tools does not force the model
+// to call every one of them
+var requiredToolCalls = []string{ToolReportLanguage, ToolReportSentiment}
+
+// addToolCalls adds every report_* tool call of the message to the session
+// The output counts as complete when the model reported the language and sentiment and stopped
+// on its own rather than on the token limit
+func (s *parseSession) addToolCalls(message *schema.Message) {
+	if message == nil {
+		return
+	}
+
+	called := make(map[string]bool, len(message.ToolCalls))
+	for _, call := range message.ToolCalls {
+		called[call.Function.Name] = true
+		tuple, err := toolCallTuple(call)
+		if err != nil {
+			s.track(call.Function.Name+" "+call.Function.Arguments, nil, err)
+			continue
+		}
+		parser, err := s.addTuple(tuple)
+		s.track(call.Function.Name+" "+call.Function.Arguments, parser, err)
+	}
+
+	if len(message.ToolCalls) == 0 && message.Content != "" {
+		// Models without tool support answer in plain text, which carries no tool results
+		s.skippedCount++
+	}
+
+	finishReason := ""
+	if message.ResponseMeta != nil {
+		finishReason = message.ResponseMeta.FinishReason
+	}
+	s.completionSeen = finishReason != finishReasonLength
+	for _, name := range requiredToolCalls {
+		s.completionSeen = s.completionSeen && called[name]
+	}
+}
+
+// toolCallTuple decodes the arguments of a report_* tool call into its tuple form
+func toolCallTuple(call schema.ToolCall) (*RawTuple, error) {
+	arguments := []byte(call.Function.Arguments)
+
+	switch call.Function.Name {
+	case ToolReportIntent:
+		var intent model.Intent
+		if err := json.Unmarshal(arguments, &intent); err != nil {
+			return nil, fmt.Errorf("invalid %s arguments: %v", call.Function.Name, err)
+		}
+		return intentTuple(intent), nil
+	case ToolReportEntity:
+		var entity model.Entity
+		if err := json.Unmarshal(arguments, &entity); err != nil {
+			return nil, fmt.Errorf("invalid %s arguments: %v", call.Function.Name, err)
+		}
+		return entityTuple(entity), nil
+	case ToolReportLanguage:
+		var language model.Language
+		if err := json.Unmarshal(arguments, &language); err != nil {
+			return nil, fmt.Errorf("invalid %s arguments: %v", call.Function.Name, err)
+		}
+		return languageTuple(language), nil
+	case ToolReportSentiment:
+		var sentiment model.Sentiment
+		if err := json.Unmarshal(arguments, &sentiment); err != nil {
+			return nil, fmt.Errorf("invalid %s arguments: %v", call.Function.Name, err)
+		}
+		return sentimentTuple(sentiment), nil
+	case ToolReportAspectSentiment:
+		var aspect model.AspectSentiment
+		if err := json.Unmarshal(arguments, &aspect); err != nil {
+			return nil, fmt.Errorf("invalid %s arguments: %v", call.Function.Name, err)
+		}
+		return aspectSentimentTuple(aspect), nil
+	default:
+		return nil, fmt.Errorf("unknown tool call: %s", call.Function.Name)
+	}
+}
+
+// ToolCallsText renders the tool calls of a message as "name arguments" lines
+// Used in place of the empty message content when storing tools mode responses
+func ToolCallsText(message *schema.Message) string {
+	lines := make([]string, 0, len(message.ToolCalls))
+	for _, call := range message.ToolCalls {
+		lines = append(lines, call.Function.Name+" "+call.Function.Arguments)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,40 @@
+package nlu
+
+import (
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestParseToolCallsCompletion(t *testing.T) {
+	intent := toolCall(ToolReportIntent, `{"name":"purchase_intent","confidence":0.9,"priority":0.8}`)
+	language := toolCall(ToolReportLanguage, `{"code":"tha","confidence":0.9,"is_primary":true}`)
+	sentiment := toolCall(ToolReportSentiment, `{"label":"neutral","confidence":0.8}`)
+
+	tests := []struct {
+		name         string
+		calls        []schema.ToolCall
+		finishReason string
+		want         bool
+	}{
+		{"all reports", []schema.ToolCall{intent, language, sentiment}, "tool_calls", true},
+		{"intent only", []schema.ToolCall{intent}, "tool_calls", false},
+		{"no sentiment", []schema.ToolCall{intent, language}, "tool_calls", false},
+		{"cut off", []schema.ToolCall{intent, language, sentiment}, finishReasonLength, false},
+		{"no calls", nil, "stop", false},
+	}
+	processor := NewNLUProcessor(WithOutputMode(OutputModeTools))
+	for _, tt := range tests {
+		message := schema.AssistantMessage("", tt.calls)
+		message.ResponseMeta = &schema.ResponseMeta{FinishReason: tt.finishReason}
+		response, _ := processor.ParseToolCalls(message)
+		if got := response.ParsingMetadata["completion_seen"]; got != tt.want {
+			t.Errorf("%s: completion_seen = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// toolCall builds a tool call of the model with JSON arguments
+func toolCall(name, arguments string) schema.ToolCall {
+	return schema.ToolCall{Function: schema.FunctionCall{Name: name, Arguments: arguments}}
+}
@@ -0,0 +1,155 @@
+package nlu
+
+import (
+	"context"
+	"eino_llm_poc/src/model"
+	"fmt"
+	"strings"
+
+	einomodel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// DefaultMaxContinuations is the number of continuation requests made for truncated output
+const DefaultMaxContinuations = 2
+
+// Message Extra keys set on stitched model output
+const (
+	ExtraContinuations = "nlu_continuations"
+	ExtraTruncated     = "nlu_truncated"
+)
+
+// Bounds of the repeated text searched for when joining continuations
+// Shorter overlaps are too likely to be a coincidence, e.g. a digit of a cut-off confidence
+const (
+	minStitchOverlap = 8
+	maxStitchOverlap = 500
+)
+
+// IsTruncated reports whether the model output was cut off by the token limit
+// Output that already contains the completion delimiter is complete even when finish_reason is length
+func (n *NLUProcessor) IsTruncated(message *schema.Message) bool {
+	if message == nil || message.ResponseMeta == nil || message.ResponseMeta.FinishReason != finishReasonLength {
+		return false
+	}
+	if n.config.OutputMode == OutputModeTuple {
+		idx, _ := nextMarker(message.Content, n.completionMarkers())
+		return idx < 0
+	}
+	return true
+}
+
+// ContinueTruncated asks the model to continue truncated output and stitches the parts into one message
+// input is the conversation that produced output and opts the call options it was made with; tools
+// mode output is returned as is because cut-off tool call arguments cannot be continued
+func (n *NLUProcessor) ContinueTruncated(ctx context.Context, chatModel einomodel.BaseChatModel, input []*schema.Message, output *schema.Message, opts ...einomodel.Option) (*schema.Message, error) {
+	if !n.IsTruncated(output) {
+		return output, nil
+	}
+
+	stitched := &schema.Message{
+		Role:         output.Role,
+		Content:      output.Content,
+		ToolCalls:    output.ToolCalls,
+		ResponseMeta: output.ResponseMeta,
+		Extra:        cloneMetadata(output.Extra),
+	}
+	if n.config.OutputMode == OutputModeTools {
+		stitched.Extra[ExtraTruncated] = true
+		return stitched, nil
+	}
+
+	continuations := 0
+	for continuations < n.config.MaxContinuations && n.IsTruncated(stitched) {
+		messages := make([]*schema.Message, 0, len(input)+2)
+		messages = append(messages, input...)
+		messages = append(messages,
+			schema.AssistantMessage(stitched.Content, nil),
+			schema.UserMessage(n.continuationPrompt()),
+		)
+
+		next, err := chatModel.Generate(ctx, messages, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("continuation request failed: %w", err)
+		}
+		continuations++
+
+		stitched.Content = n.stitch(stitched.Content, next.Content)
+		stitched.ResponseMeta = mergeResponseMeta(stitched.ResponseMeta, next.ResponseMeta)
+	}
+
+	stitched.Extra[ExtraContinuations] = continuations
+	stitched.Extra[ExtraTruncated] = n.IsTruncated(stitched)
+	return stitched, nil
+}
+
+// continuationPrompt asks the model to resume exactly where the output stopped
+func (n *NLUProcessor) continuationPrompt() string {
+	if n.config.OutputMode == OutputModeJSON {
+		return "Your previous output was cut off. Continue the JSON object exactly where it stopped, " +
+			"without repeating anything already written and without code fences."
+	}
+	return fmt.Sprintf("Your previous output was cut off. Continue exactly where it stopped, "+
+		"without repeating complete records, and end with %s on its own line.", n.config.CompletionDelimiter)
+}
+
+// stitch joins truncated output with its continuation
+// Text the continuation repeats is removed; a record restarted from its beginning replaces the partial one
+func (n *NLUProcessor) stitch(previous, continuation string) string {
+	continuation = strings.TrimLeft(continuation, " ")
+
+	// Longest suffix of previous that the continuation starts with
+	maxOverlap := min(len(previous), len(continuation), maxStitchOverlap)
+	for overlap := maxOverlap; overlap >= minStitchOverlap; overlap-- {
+		if strings.HasSuffix(previous, continuation[:overlap]) {
+			return previous + continuation[overlap:]
+		}
+	}
+
+	if n.config.OutputMode == OutputModeTuple && strings.HasPrefix(strings.TrimSpace(continuation), "(") {
+		lastRecord := previous
+		if idx := strings.LastIndex(previous, n.config.RecordDelimiter); idx >= 0 {
+			lastRecord = previous[idx+len(n.config.RecordDelimiter):]
+		}
+		if strings.HasPrefix(strings.TrimSpace(lastRecord), "(") {
+			// The model restarted the cut-off record instead of continuing it
+			return strings.TrimRight(previous[:len(previous)-len(lastRecord)], " ") + continuation
+		}
+	}
+	return previous + continuation
+}
+
+// mergeResponseMeta keeps the finish reason of the latest response and sums token usage
+func mergeResponseMeta(previous, next *schema.ResponseMeta) *schema.ResponseMeta {
+	if next == nil {
+		return previous
+	}
+	merged := *next
+	if previous != nil && previous.Usage != nil && next.Usage != nil {
+		merged.Usage = &schema.TokenUsage{
+			PromptTokens:     previous.Usage.PromptTokens + next.Usage.PromptTokens,
+			CompletionTokens: previous.Usage.CompletionTokens + next.Usage.CompletionTokens,
+			TotalTokens:      previous.Usage.TotalTokens + next.Usage.TotalTokens,
+		}
+	}
+	return &merged
+}
+
+// recordTruncation records in ParsingMetadata whether the parsed output was cut off
+// and whether continuation requests recovered it
+func (n *NLUProcessor) recordTruncation(response *model.NLUResponse, message *schema.Message) {
+	if response == nil || message == nil {
+		return
+	}
+	continuations, _ := message.Extra[ExtraContinuations].(int)
+	truncated, stitched := message.Extra[ExtraTruncated].(bool)
+	if !stitched {
+		truncated = n.IsTruncated(message)
+	}
+	if continuations == 0 && !truncated {
+		return
+	}
+	response.ParsingMetadata["continuations"] = continuations
+	response.ParsingMetadata["truncated"] = truncated
+	response.ParsingMetadata["truncation_recovered"] = continuations > 0 && !truncated
+}
@@ -25,6 +25,8 @@ type ConversationConfig struct {
 
 // NLUConfig holds configuration for the NLU system
 type NLUConfig struct {
+	Provider            string  `envconfig:"NLU_PROVIDER" default:"openai"` // openai (any OpenAI-compatible API), ollama, deepseek, ark
+	BaseURL             string  `envconfig:"NLU_BASE_URL" default:""`       // empty uses the provider default, OpenRouter for openai
 	Model               string  `envconfig:"NLU_MODEL" default:"openai/gpt-3.5-turbo"`
 	MaxTokens           int     `envconfig:"NLU_MAX_TOKENS" default:"2000"`
 	Temperature         float32 `envconfig:"NLU_TEMPERATURE" default:"0.1"`
@@ -33,6 +35,51 @@ type NLUConfig struct {
 	AdditionalIntent    string  `envconfig:"NLU_ADDITIONAL_INTENT" default:"complaint:0.5, cancel_order:0.4, ask_price:0.6, compare_product:0.5, delivery_issue:0.7"`
 	DefaultEntity       string  `envconfig:"NLU_DEFAULT_ENTITY" default:"product, quantity, brand, price"`
 	AdditionalEntity    string  `envconfig:"NLU_ADDITIONAL_ENTITY" default:"color, model, spec, budget, warranty, delivery"`
+	CatalogFile         string  `envconfig:"NLU_CATALOG_FILE" default:""`      // YAML intent/entity catalog, replaces the four lists above
+	ParseMode           string  `envconfig:"NLU_PARSE_MODE" default:"lenient"` // lenient, strict
+	FormatRecovery      bool    `envconfig:"NLU_FORMAT_RECOVERY" default:"true"`
+	OutputMode          string  `envconfig:"NLU_OUTPUT_MODE" default:"tuple"`         // tuple, json, tools
+	PromptVersion       string  `envconfig:"NLU_PROMPT_VERSION" default:"v3"`         // embedded prompt version, v1 and v2 are tuple mode only
+	OutputModeOverrides string  `envconfig:"NLU_OUTPUT_MODE_OVERRIDES" default:""`    // per-model "vendor/model:json" list
+	CalibrationFile     string  `envconfig:"NLU_CALIBRATION_FILE" default:""`         // fitted by cmd/calibrate, empty disables calibration
+	MaxRepairAttempts   int     `envconfig:"NLU_MAX_REPAIR_ATTEMPTS" default:"1"`     // repair rounds for unparseable output, 0 disables
+	MaxContinuations    int     `envconfig:"NLU_MAX_CONTINUATIONS" default:"2"`       // continuation requests for output cut off by NLU_MAX_TOKENS
+	EntityNormalization bool    `envconfig:"NLU_ENTITY_NORMALIZATION" default:"true"` // typed values for price, budget and quantity
+	DefaultCurrency     string  `envconfig:"NLU_DEFAULT_CURRENCY" default:"THB"`      // currency of prices without a marker
+	ProductCatalogFile  string  `envconfig:"NLU_PRODUCT_CATALOG_FILE" default:""`     // .json or .csv products, empty disables entity linking
+	LinkThreshold       float64 `envconfig:"NLU_LINK_THRESHOLD" default:"0.7"`        // minimum score for linked_product_id
+	LinkMaxCandidates   int     `envconfig:"NLU_LINK_MAX_CANDIDATES" default:"3"`     // candidates attached to each entity
+	ExampleBankFile     string  `envconfig:"NLU_EXAMPLE_BANK_FILE" default:""`        // labeled JSONL examples, empty disables few-shot retrieval
+	FewShotK            int     `envconfig:"NLU_FEW_SHOT_K" default:"3"`              // examples retrieved per query
+
+	// Per-tenant and per-channel catalogs selected by each request, the catalog above is the fallback
+	CatalogDir         string        `envconfig:"NLU_CATALOG_DIR" default:""`            // catalog files named tenant/<tenant>[/<channel>].yaml or channel/<channel>.yaml, empty disables
+	CatalogRedisPrefix string        `envconfig:"NLU_CATALOG_REDIS_PREFIX" default:""`   // Redis key prefix of catalogs stored under the same keys, empty disables
+	CatalogCacheTTL    time.Duration `envconfig:"NLU_CATALOG_CACHE_TTL" default:"5m"`    // how long resolved catalogs and their rendered prompts are cached
+	CatalogCacheSize   int           `envconfig:"NLU_CATALOG_CACHE_SIZE" default:"1000"` // most recently used tenant/channel selectors kept in the cache
+
+	ExperimentFile string `envconfig:"NLU_EXPERIMENT_FILE" default:""` // YAML prompt/model variants split by customer, empty disables experiments
+
+	// Models tried in order when NLU_MODEL fails on a retriable error or uses up its token budget
+	FallbackModels    string        `envconfig:"NLU_FALLBACK_MODELS" default:""`       // "provider:model" or models of NLU_PROVIDER, comma-separated
+	TokenBudget       int           `envconfig:"NLU_TOKEN_BUDGET" default:"0"`         // tokens NLU_MODEL may use per window before the fallbacks take over, 0 for unlimited
+	TokenBudgetWindow time.Duration `envconfig:"NLU_TOKEN_BUDGET_WINDOW" default:"1h"` // period NLU_TOKEN_BUDGET is counted over
+
+	// Timeout, retries and circuit breaker of each NLU model, applied before falling back to the next one
+	CallTimeout      time.Duration `envconfig:"NLU_CALL_TIMEOUT" default:"30s"`       // per attempt, 0 for none
+	MaxRetries       int           `envconfig:"NLU_MAX_RETRIES" default:"2"`          // retries on timeouts, 429 and 5xx after the first attempt
+	RetryBaseDelay   time.Duration `envconfig:"NLU_RETRY_BASE_DELAY" default:"500ms"` // first backoff delay, doubled per retry with jitter
+	RetryMaxDelay    time.Duration `envconfig:"NLU_RETRY_MAX_DELAY" default:"10s"`    // longest backoff; a longer Retry-After ends the retries
+	BreakerThreshold int           `envconfig:"NLU_BREAKER_THRESHOLD" default:"5"`    // consecutive failures that open the circuit breaker, 0 disables it
+	BreakerCooldown  time.Duration `envconfig:"NLU_BREAKER_COOLDOWN" default:"30s"`   // how long an open breaker fast-fails before a trial call
+
+	// Importance scoring weights, the defaults reproduce 0.6*confidence + 0.4*priority
+	ImportanceConfidenceWeight float64 `envconfig:"NLU_IMPORTANCE_CONFIDENCE_WEIGHT" default:"0.6"`
+	ImportancePriorityWeight   float64 `envconfig:"NLU_IMPORTANCE_PRIORITY_WEIGHT" default:"0.4"`
+	ImportanceSecondaryWeight  float64 `envconfig:"NLU_IMPORTANCE_SECONDARY_WEIGHT" default:"0"`
+	ImportanceNegativeBoost    float64 `envconfig:"NLU_IMPORTANCE_NEGATIVE_BOOST" default:"0"`
+	ImportanceEntityBonus      float64 `envconfig:"NLU_IMPORTANCE_ENTITY_BONUS" default:"0"`
+	ImportanceMaxEntityBonus   float64 `envconfig:"NLU_IMPORTANCE_MAX_ENTITY_BONUS" default:"0.1"`
 }
 
 // ================ Response ================
@@ -46,16 +93,26 @@ type Intent struct {
 
 // Entity represents an extracted entity from user input
 type Entity struct {
-	Type       string         `json:"type"`
-	Value      string         `json:"value"`
-	Confidence float64        `json:"confidence"`
-	Position   []int          `json:"position,omitempty"`
-	Metadata   map[string]any `json:"metadata"`
+	Type       string           `json:"type"`
+	Value      string           `json:"value"`
+	Confidence float64          `json:"confidence"`
+	Position   []int            `json:"position,omitempty"`
+	Normalized *NormalizedValue `json:"normalized,omitempty"` // typed value of price, budget and quantity entities
+	Metadata   map[string]any   `json:"metadata"`
+}
+
+// NormalizedValue is the typed form of a price, budget or quantity entity
+type NormalizedValue struct {
+	Amount    float64 `json:"amount"`               // value, lower bound for ranges
+	MaxAmount float64 `json:"max_amount,omitempty"` // upper bound for ranges
+	Currency  string  `json:"currency,omitempty"`   // ISO 4217 code for price and budget
+	Unit      string  `json:"unit,omitempty"`       // counting unit for quantities, e.g. pair
+	Qualifier string  `json:"qualifier,omitempty"`  // approx, min or max when the value is an estimate or bound
 }
 
 // Language represents detected language information
 type Language struct {
-	Code       string         `json:"code"` // ISO 3166 Alpha-3 code
+	Code       string         `json:"code"` // ISO 639-3 code
 	Confidence float64        `json:"confidence"`
 	IsPrimary  bool           `json:"is_primary"` // 1 for primary, 0 for contained
 	Metadata   map[string]any `json:"metadata"`
@@ -68,16 +125,37 @@ type Sentiment struct {
 	Metadata   map[string]any `json:"metadata"`
 }
 
+// AspectSentiment represents sentiment towards a single aspect of the message,
+// e.g. positive about the product but negative about delivery
+type AspectSentiment struct {
+	Aspect     string         `json:"aspect"` // entity type the opinion is about
+	Span       string         `json:"span"`   // literal text of the aspect in the message
+	Label      string         `json:"label"`  // positive, negative, neutral
+	Confidence float64        `json:"confidence"`
+	Position   []int          `json:"position,omitempty"`
+	Metadata   map[string]any `json:"metadata"`
+}
+
+// Extension represents a value of a custom tuple type registered with the NLU parser
+type Extension struct {
+	Label      string         `json:"label"`
+	Confidence float64        `json:"confidence"`
+	Metadata   map[string]any `json:"metadata"`
+}
+
 // NLUResponse contains structured output from NLU processing
 type NLUResponse struct {
-	Intents         []Intent       `json:"intents"`
-	Entities        []Entity       `json:"entities"`
-	Languages       []Language     `json:"languages"`
-	Sentiment       Sentiment      `json:"sentiment"`
-	ImportanceScore float64        `json:"importance_score"`
-	PrimaryIntent   string         `json:"primary_intent"`
-	PrimaryLanguage string         `json:"primary_language"`
-	Metadata        map[string]any `json:"metadata"`
-	ParsingMetadata map[string]any `json:"parsing_metadata"`
-	Timestamp       time.Time      `json:"timestamp"`
+	Intents          []Intent          `json:"intents"`
+	Entities         []Entity          `json:"entities"`
+	Languages        []Language        `json:"languages"`
+	Sentiment        Sentiment         `json:"sentiment"`
+	AspectSentiments []AspectSentiment `json:"aspect_sentiments"`
+	Extensions       map[string]any    `json:"extensions,omitempty"` // custom tuple types keyed by type name
+	ImportanceScore  float64           `json:"importance_score"`
+	PrimaryIntent    string            `json:"primary_intent"`
+	PrimaryLanguage  string            `json:"primary_language"`
+	Metadata         map[string]any    `json:"metadata"`
+	ParsingMetadata  map[string]any    `json:"parsing_metadata"`
+	Variant          string            `json:"variant,omitempty"` // experiment variant that served the request
+	Timestamp        time.Time         `json:"timestamp"`
 }
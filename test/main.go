@@ -3,13 +3,13 @@ package main
 import (
 	"context"
 	"eino_llm_poc/src"
+	"eino_llm_poc/src/llm/chatmodel"
 	"eino_llm_poc/src/llm/nlu"
 	"eino_llm_poc/src/logger"
 	"fmt"
 	"os"
 	"time"
 
-	"github.com/cloudwego/eino-ext/components/model/openai"
 	"github.com/cloudwego/eino/compose"
 	"github.com/cloudwego/eino/schema"
 	"github.com/joho/godotenv"
@@ -34,8 +34,10 @@ func main() {
 	if err := godotenv.Load(); err != nil {
 		// Will use default values if .env not found
 	}
-	apiKey := os.Getenv("OPENROUTER_API_KEY")
-	baseURL := os.Getenv("OPENROUTER_BASE_URL")
+	apiKey := os.Getenv("NLU_API_KEY")
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENROUTER_API_KEY")
+	}
 	config, err := src.LoadConfig()
 	if err != nil {
 		fmt.Printf("Error loading config: %v\n", err)
@@ -52,25 +54,27 @@ func main() {
 			return "yes"
 		}
 		return "no"
-	}()).Str("base_url", baseURL).Msg("Environment configuration loaded")
+	}()).Str("provider", config.NLUConfig.Provider).Str("base_url", config.NLUConfig.BaseURL).Msg("Environment configuration loaded")
 	logger.Debug().Interface("nlu_config", config.NLUConfig).Msg("NLU configuration loaded")
 
-	// สร้าง model configuration with NLU config injection
-	modelConfig := &openai.ChatModelConfig{
-		APIKey:      apiKey,
-		BaseURL:     "https://openrouter.ai/api/v1",
-		Model:       config.NLUConfig.Model,
-		MaxTokens:   &config.NLUConfig.MaxTokens,
-		Temperature: &config.NLUConfig.Temperature,
-	}
-
+	// สร้าง model ของ provider ที่ตั้งค่าไว้ with NLU config injection
 	ctx := context.Background()
-	chatModel, err := openai.NewChatModel(ctx, modelConfig)
+	chatModel, err := chatmodel.NewChatModel(ctx, config.NLUConfig.Provider, config.NLUConfig.BaseURL, config.NLUConfig.Model,
+		chatmodel.WithAPIKey(apiKey),
+		chatmodel.WithMaxTokens(config.NLUConfig.MaxTokens),
+		chatmodel.WithTemperature(config.NLUConfig.Temperature),
+	)
 	if err != nil {
 		logger.Error().Err(err).Msg("Error creating model")
 		return
 	}
 
+	systemPrompt, err := nlu.GetSystemTemplateProcessed(&config.NLUConfig)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error loading NLU system prompt")
+		return
+	}
+
 	g := compose.NewGraph[QueryInput, QueryOutput]()
 
 	// Create NLU template as InvokableLambda node
@@ -87,7 +91,7 @@ func main() {
 			</current_message_to_analyze>`
 
 		messages := []*schema.Message{
-			schema.SystemMessage(nlu.GetSystemTemplateProcessed(&config.NLUConfig)),
+			schema.SystemMessage(systemPrompt),
 			schema.UserMessage(NLUinput),
 		}
 		logger.Debug().Int("message_count", len(messages)).Msg("Generated NLU template messages")